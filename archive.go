@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readArchive returns a map from member name to size
+// for the Unix ar archive at path, such as a Go package archive.
+func readArchive(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseArchive(bufio.NewReader(f))
+}
+
+func parseArchive(r io.Reader) (map[string]int64, error) {
+	const magic = "!<arch>\n"
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != magic {
+		return nil, errors.New("not an archive")
+	}
+	m := make(map[string]int64)
+	// Each member has a 60 byte header:
+	// name (16), mtime (12), uid (6), gid (6), mode (8), size (10), magic (2).
+	hdr := make([]byte, 60)
+	for {
+		_, err := io.ReadFull(r, hdr)
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(hdr[58:60], []byte("`\n")) {
+			return nil, errors.New("malformed archive header")
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed archive member size: %v", err)
+		}
+		m[name] += size
+		// Member data is padded to an even length.
+		if _, err := io.CopyN(io.Discard, r, size+size&1); err != nil {
+			if err == io.EOF && size&1 == 1 {
+				// Missing trailing pad byte.
+				return m, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// archiveMemberKind describes the contents of a Go package archive member.
+func archiveMemberKind(name string) string {
+	switch name {
+	case "__.PKGDEF":
+		return "export data"
+	case "_go_.o":
+		return "object code"
+	}
+	return "other"
+}
+
+// compareArchiveMembers prints the size changes of the members of
+// the package archives in beforeMap and afterMap (maps from import path to archive),
+// followed by a summary for each kind of member, and records them in rep.
+func compareArchiveMembers(sorted []string, beforeMap, afterMap map[string]string, rep *report) {
+	read := func(path string) map[string]int64 {
+		if path == "" {
+			return nil
+		}
+		m, err := readArchive(path)
+		check(err)
+		return m
+	}
+	members := newSizeTable(stdout, "member")
+	kinds := map[string]*sizeRecord{}
+	var kindNames []string
+	for _, pkg := range sorted {
+		b := read(beforeMap[pkg])
+		a := read(afterMap[pkg])
+		var names []string
+		for name := range b {
+			names = append(names, name)
+		}
+		for name := range a {
+			if _, ok := b[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			members.add(pkg+"/"+name, b[name], a[name])
+			kind := archiveMemberKind(name)
+			k, ok := kinds[kind]
+			if !ok {
+				k = &sizeRecord{Name: kind}
+				kinds[kind] = k
+				kindNames = append(kindNames, kind)
+			}
+			k.Before += b[name]
+			k.After += a[name]
+		}
+	}
+	fmt.Fprintln(stdout)
+	members.flush("archive member")
+	rep.ObjectMembers = members.records
+
+	fmt.Fprintln(stdout)
+	summary := newSizeTable(stdout, "kind")
+	sort.Strings(kindNames)
+	for _, kind := range kindNames {
+		summary.add(kind, kinds[kind].Before, kinds[kind].After)
+	}
+	summary.flush("archive member kind")
+	rep.ObjectKinds = summary.records
+}
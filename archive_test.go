@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArchive(t *testing.T) {
+	hdr := func(name string, size int) string {
+		return fmt.Sprintf("%-16s%-32s%-10d`\n", name, "", size)
+	}
+	in := "!<arch>\n" +
+		hdr("__.PKGDEF", 3) + "abc\n" +
+		hdr("_go_.o/", 4) + "defg"
+	got, err := parseArchive(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"__.PKGDEF": 3, "_go_.o": 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseArchive = %v, want %v", got, want)
+	}
+	if _, err := parseArchive(strings.NewReader("not an archive")); err == nil {
+		t.Errorf("parseArchive of non-archive succeeded")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// normalizeAsmLine converts a line of -S output for a function body
+// into a form suitable for diffing.
+// Instruction lines lose their pc and position, so that the diff aligns
+// instructions even when code or source lines have moved.
+// Encoding lines (hex dumps and relocations) are dropped;
+// ok reports whether the line should be kept.
+func normalizeAsmLine(line string) (s string, ok bool) {
+	line = strings.TrimPrefix(line, "\t")
+	if strings.HasPrefix(line, "rel ") {
+		return "", false
+	}
+	if !strings.HasPrefix(line, "0x") {
+		return line, true
+	}
+	// Instruction lines look like
+	//	0x0012 00018 (/path/to/file.go:10)	MOVQ	AX, BX
+	// Hex dump lines look like
+	//	0x0000 48 8b 44 24 08 48 89 ...
+	// The decimal pc has at least 5 digits; a hex dump byte has 2.
+	f := strings.SplitN(line, " ", 3)
+	if len(f) < 3 || len(f[1]) < 5 || strings.Trim(f[1], "0123456789") != "" || !strings.HasPrefix(f[2], "(") {
+		return "", false
+	}
+	i := strings.Index(f[2], ")\t")
+	if i < 0 {
+		return "", false
+	}
+	return f[2][i+2:], true
+}
+
+// A diffOp is a single line of an edit script:
+// kind is ' ' for a common line, '-' for a deleted line, and '+' for an inserted line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns a minimal edit script transforming a into b,
+// using the linear space variant of Myers' O(ND) algorithm,
+// so that large functions with many changes do not use quadratic memory.
+// Within each run of changes, deletions come before insertions.
+func diffLines(a, b []string) []diffOp {
+	ops := appendDiff(nil, a, b)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(ops) && ops[j].kind != ' ' {
+			j++
+		}
+		sort.SliceStable(ops[i:j], func(x, y int) bool {
+			return ops[i+x].kind == '-' && ops[i+y].kind == '+'
+		})
+		i = j
+	}
+	return ops
+}
+
+// appendDiff appends an edit script transforming a into b to ops.
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	suffix := a[len(a)-n:]
+	a, b = a[:len(a)-n], b[:len(b)-n]
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+	default:
+		// With no common prefix or suffix, at least two edits are needed,
+		// so the middle snake splits the problem into two smaller ones.
+		x, y, u, v := middleSnake(a, b)
+		ops = appendDiff(ops, a[:x], b[:y])
+		for _, line := range a[x:u] {
+			ops = append(ops, diffOp{' ', line})
+		}
+		ops = appendDiff(ops, a[u:], b[v:])
+	}
+	for _, line := range suffix {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// middleSnake returns the middle snake of an optimal edit script transforming a into b:
+// a run of common lines from (x, y) to (u, v) that splits the script's edits in half.
+// It searches forward from the start and backward from the end at once,
+// keeping only the furthest point reached on each diagonal in each direction.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	max := (n+m+1)/2 + 1
+	// fwd[off+k] is the furthest x reached forward on diagonal k = x - y,
+	// and bwd[off-delta+k] the furthest (smallest) x reached backward on diagonal k.
+	off := max + 1
+	fwd := make([]int, 2*max+3)
+	bwd := make([]int, 2*max+3)
+	fwd[off+1] = 0
+	bwd[off-1] = n
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || k != d && fwd[off+k-1] < fwd[off+k+1] {
+				x = fwd[off+k+1]
+			} else {
+				x = fwd[off+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			fwd[off+k] = u
+			// The backward search on diagonal k has had d-1 steps.
+			if kb := k - delta; delta%2 != 0 && kb >= -(d-1) && kb <= d-1 && u >= bwd[off+kb] {
+				return x, y, u, v
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			// k is relative to the diagonal delta, where the backward search starts.
+			if k == d || k != -d && bwd[off+k-1] < bwd[off+k+1] {
+				u = bwd[off+k-1]
+			} else {
+				u = bwd[off+k+1] - 1
+			}
+			v = u - (k + delta)
+			x, y = u, v
+			for x > 0 && y > 0 && a[x-1] == b[y-1] {
+				x--
+				y--
+			}
+			bwd[off+k] = x
+			// The forward search on diagonal k+delta has had d steps.
+			if f := k + delta; delta%2 == 0 && f >= -d && f <= d && fwd[off+f] >= x {
+				return x, y, u, v
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+// unifiedDiff returns a unified diff of a and b, with three lines of context.
+// It returns the empty string if a and b are identical.
+func unifiedDiff(aName, bName string, a, b []string) string {
+	const ctx = 3
+	ops := diffLines(a, b)
+	// aLine[i] and bLine[i] are the number of lines of a and b preceding ops[i].
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	var buf strings.Builder
+	prevEnd := 0
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := i - ctx
+		if start < prevEnd {
+			start = prevEnd
+		}
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			j := end
+			for j < len(ops) && ops[j].kind == ' ' {
+				j++
+			}
+			if j < len(ops) && j-end <= 2*ctx {
+				end = j
+				continue
+			}
+			end += ctx
+			if end > len(ops) {
+				end = len(ops)
+			}
+			break
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[end]-aLine[start]),
+			hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&buf, "%c%s\n", op.kind, op.line)
+		}
+		prevEnd = end
+		i = end
+	}
+	return buf.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
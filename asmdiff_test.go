@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAsmLine(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"\t0x0012 00018 (/src/x.go:10)\tMOVQ\tAX, BX", "MOVQ\tAX, BX", true},
+		{"\t0x186a5 100005 (/src/x.go:9000)\tRET", "RET", true},
+		{"\t0x0000 48 8b 44 24 08 48 89 44  H.D$.H.D", "", false},
+		{"\trel 3+4 t=R_CALL runtime.morestack+0", "", false},
+	}
+	for _, test := range cases {
+		got, ok := normalizeAsmLine(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("normalizeAsmLine(%q)=%q, %v, want %q, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tt := range []struct {
+		a, b  string
+		edits int
+	}{
+		{"a b c a b b a", "c b a b a c", 5},
+		{"x y z", "", 3},
+		{"", "x y z", 3},
+		{"a b c d", "d c b a", 6},
+		{"a x b x c x", "x a x b x c", 2},
+	} {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		var edits int
+		var gotA, gotB []string
+		for _, op := range diffLines(a, b) {
+			if op.kind != ' ' {
+				edits++
+			}
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+		}
+		if edits != tt.edits || strings.Join(gotA, " ") != tt.a || strings.Join(gotB, " ") != tt.b {
+			t.Errorf("diffLines(%q, %q) has %d edits, transforming %q to %q; want %d edits", tt.a, tt.b, edits, gotA, gotB, tt.edits)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Fields("a b c d e f g h i j")
+	b := strings.Fields("a b c D e f g h i j k")
+	got := unifiedDiff("before", "after", a, b)
+	want := `--- before
++++ after
+@@ -1,10 +1,11 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+ h
+ i
+ j
++k
+`
+	if got != want {
+		t.Errorf("unifiedDiff:\n%s\nwant:\n%s", got, want)
+	}
+
+	a = strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12 13 14 15")
+	b = strings.Fields("x 2 3 4 5 6 7 8 9 10 11 12 13 14")
+	got = unifiedDiff("before", "after", a, b)
+	want = `--- before
++++ after
+@@ -1,4 +1,4 @@
+-1
++x
+ 2
+ 3
+ 4
+@@ -12,4 +12,3 @@
+ 12
+ 13
+ 14
+-15
+`
+	if got != want {
+		t.Errorf("unifiedDiff:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := unifiedDiff("before", "after", a, a); got != "" {
+		t.Errorf("unifiedDiff of identical input = %q, want empty", got)
+	}
+}
+
+func TestScanDashSMovedCode(t *testing.T) {
+	scan := func(out string) stextFunc {
+		c := make(chan *pkgScanner)
+		go scanDashS(strings.NewReader(out), []byte("0123abcd"), c)
+		var f stextFunc
+		for p := range c {
+			f = p.Funcs["p.f"]
+		}
+		return f
+	}
+	const before = "# p\np.f STEXT size=8 args=0x0 locals=0x0\n" +
+		"\t0x0000 00000 (/src/p/x.go:10)\tMOVQ\tAX, BX\n" +
+		"\t0x0003 00003 (/src/p/x.go:11)\tRET\n" +
+		"\t0x0000 48 89 c3 c3\n"
+	moved := strings.ReplaceAll(before, "x.go:1", "x.go:2")
+	changed := strings.Replace(before, "AX, BX", "CX, BX", 1)
+	a, b, c := scan(before), scan(moved), scan(changed)
+	if a.bodyhash == nil || string(a.bodyhash) != string(b.bodyhash) {
+		t.Errorf("moved code has a different body hash")
+	}
+	if string(a.bodyhash) == string(c.bodyhash) {
+		t.Errorf("changed code has the same body hash")
+	}
+}
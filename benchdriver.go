@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A compileBenchmark measures the compilation of a single package,
+// or, if pkg is empty, a full build of std and cmd.
+type compileBenchmark struct {
+	name string
+	pkg  string
+	long bool // run only with -all
+}
+
+// compileBenchmarks are the default benchmarks, modeled on compilebench's.
+var compileBenchmarks = []compileBenchmark{
+	{name: "Template", pkg: "html/template"},
+	{name: "Unicode", pkg: "unicode"},
+	{name: "GoTypes", pkg: "go/types"},
+	{name: "Compiler", pkg: "cmd/compile/internal/gc"},
+	{name: "SSA", pkg: "cmd/compile/internal/ssa", long: true},
+	{name: "Flate", pkg: "compress/flate"},
+	{name: "GoParser", pkg: "go/parser"},
+	{name: "Reflect", pkg: "reflect"},
+	{name: "Tar", pkg: "archive/tar"},
+	{name: "XML", pkg: "encoding/xml"},
+	{name: "StdCmd", long: true},
+}
+
+// selectedBenchmarks returns the benchmarks selected by -pkg, -all, and -run.
+func selectedBenchmarks() []compileBenchmark {
+	if *flagPkg != "" {
+		return []compileBenchmark{{name: "Pkg/" + *flagPkg, pkg: *flagPkg}}
+	}
+	var run *regexp.Regexp
+	if *flagRun != "" {
+		var err error
+		run, err = regexp.Compile(*flagRun)
+		if err != nil {
+			log.Fatalf("bad -run: %v", err)
+		}
+	}
+	var list []compileBenchmark
+	for _, b := range compileBenchmarks {
+		if b.long && !*flagAll {
+			continue
+		}
+		if run != nil && !run.MatchString(b.name) {
+			continue
+		}
+		list = append(list, b)
+	}
+	return list
+}
+
+// units returns the units that a run of b reports (see measurement.write).
+func (b compileBenchmark) units() []string {
+	units := []string{"ns/op", "user-ns/op", "sys-ns/op"}
+	if havePeakRSS && !*flagCPU {
+		units = append(units, "peak-RSS-bytes")
+	}
+	if b.pkg != "" && *flagObj {
+		units = append(units, "obj-bytes")
+	}
+	return units
+}
+
+// A measurement is the cost of a single benchmark run.
+type measurement struct {
+	wall, user, sys time.Duration
+	peakRSS         int64 // bytes; 0 if unavailable
+	objSize         int64 // bytes; 0 if not applicable
+}
+
+// write writes m to w in Go benchmark format.
+func (m measurement) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "Benchmark%s 1 %d ns/op %d user-ns/op %d sys-ns/op", name, m.wall, m.user, m.sys)
+	if m.peakRSS > 0 && !*flagCPU {
+		fmt.Fprintf(w, " %d peak-RSS-bytes", m.peakRSS)
+	}
+	if m.objSize > 0 && *flagObj {
+		fmt.Fprintf(w, " %d obj-bytes", m.objSize)
+	}
+	fmt.Fprintln(w)
+}
+
+// measure runs cmd and reports its wall time and resource usage,
+// including that of its children.
+func measure(cmd *exec.Cmd) measurement {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	start := time.Now()
+	err := cmd.Run()
+	wall := time.Since(start)
+	if err != nil {
+		log.Fatalf("%s\n%s: %v", out.Bytes(), cmd, err)
+	}
+	ps := cmd.ProcessState
+	return measurement{
+		wall:    wall,
+		user:    ps.UserTime(),
+		sys:     ps.SystemTime(),
+		peakRSS: peakRSS(ps),
+	}
+}
+
+// bench runs each of benchmarks once with c's toolchain,
+// and, if record is set, writes the results to c.tmp.
+func (c *commit) bench(platform string, benchmarks []compileBenchmark, record bool) {
+	for _, b := range benchmarks {
+		var m measurement
+		if b.pkg == "" {
+			m = c.benchBuild(platform)
+		} else {
+			m = c.benchCompile(platform, b.pkg)
+		}
+		if record {
+			m.write(c.tmp, b.name)
+		}
+	}
+}
+
+// benchBuild measures a full rebuild of std and cmd.
+func (c *commit) benchBuild(platform string) measurement {
+	args := append([]string{"build", "-a"}, c.flags.args("")...)
+	args = append(args, "std", "cmd")
+	cmd := exec.Command(filepath.Join(c.dir, "bin", "go"), args...)
+	cmd.Env = c.environ(platform)
+	cmd.Dir = filepath.Join(c.dir, "src")
+	return measure(cmd)
+}
+
+var (
+	toolPathsMu sync.Mutex
+	toolPaths   = map[string]string{} // GOROOT + "/" + tool -> path of tool
+)
+
+// toolPath returns the path of c's tool (such as compile), which is run directly,
+// like compilebench does, so that measurements do not include the go command.
+func (c *commit) toolPath(tool string) string {
+	key := c.dir + "/" + tool
+	toolPathsMu.Lock()
+	defer toolPathsMu.Unlock()
+	if path, ok := toolPaths[key]; ok {
+		return path
+	}
+	path := strings.TrimSpace(string(c.cmdgo("", "tool", "-n", tool)))
+	toolPaths[key] = path
+	return path
+}
+
+// A compileJob is what benchCompile needs to compile a package
+// with the toolchain of a commit, for a platform.
+type compileJob struct {
+	dir           string // package directory
+	std           bool
+	complete      bool   // whether the package is all Go, for -complete
+	lang          string // -lang value, or empty for the toolchain's default
+	files, sfiles []string
+	importcfg     []byte
+	embedcfg      []byte   // -embedcfg contents, or nil if the package embeds nothing
+	asmDefines    []string // assembler -D flags for the platform's settings
+}
+
+// A listedPackage is the part of go list -json's output
+// that a compileJob is made from.
+type listedPackage struct {
+	Dir        string
+	ImportPath string
+	Standard   bool
+	Module     *struct{ GoVersion string }
+
+	GoFiles, CgoFiles, SFiles                                            []string
+	CFiles, CXXFiles, MFiles, FFiles, SysoFiles, SwigFiles, SwigCXXFiles []string
+
+	EmbedPatterns, EmbedFiles []string
+}
+
+// newCompileJob returns the job for compiling the package p,
+// with the compiler flags that cmd/go would pass
+// (see gc in cmd/go/internal/work/gc.go).
+// Packages that use cgo cannot be compiled without cmd/cgo's generated files,
+// so it returns an error for them rather than measuring only some of their files.
+func newCompileJob(p *listedPackage) (*compileJob, error) {
+	if len(p.CgoFiles) > 0 {
+		return nil, fmt.Errorf("%s uses cgo, which compilecmp cannot benchmark; try -env CGO_ENABLED=0", p.ImportPath)
+	}
+	job := &compileJob{dir: p.Dir, std: p.Standard, files: p.GoFiles, sfiles: p.SFiles}
+	if p.Module != nil {
+		v := p.Module.GoVersion
+		if v == "" {
+			v = "1.16" // cmd/go's default for a go.mod without a go line
+		}
+		job.lang = "go" + langVersion(v)
+	}
+	ext := len(p.SFiles) + len(p.CFiles) + len(p.CXXFiles) + len(p.MFiles) + len(p.FFiles) + len(p.SysoFiles) + len(p.SwigFiles) + len(p.SwigCXXFiles)
+	switch p.ImportPath {
+	case "bytes", "internal/poll", "net", "os", "runtime/metrics", "runtime/pprof", "runtime/trace", "sync", "syscall", "time":
+		// These have declarations whose bodies are supplied by the runtime.
+		if p.Standard {
+			ext++
+		}
+	}
+	job.complete = ext == 0
+	if len(p.EmbedPatterns) > 0 {
+		var cfg struct {
+			Patterns map[string][]string
+			Files    map[string]string
+		}
+		cfg.Patterns = make(map[string][]string)
+		cfg.Files = make(map[string]string)
+		for _, pattern := range p.EmbedPatterns {
+			cfg.Patterns[pattern] = embedMatches(pattern, p.EmbedFiles)
+		}
+		for _, file := range p.EmbedFiles {
+			cfg.Files[file] = filepath.Join(p.Dir, file)
+		}
+		data, err := json.Marshal(&cfg)
+		if err != nil {
+			return nil, err
+		}
+		job.embedcfg = data
+	}
+	return job, nil
+}
+
+// langVersion returns the language version of the Go version v,
+// such as 1.21 for 1.21.3 or 1.21rc1.
+func langVersion(v string) string {
+	major, rest, _ := strings.Cut(v, ".")
+	minor := rest
+	if i := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = rest[:i]
+	}
+	if minor == "" {
+		return major
+	}
+	return major + "." + minor
+}
+
+// embedMatches returns those of files, a package's embedded files,
+// that the //go:embed pattern matches: files that match it,
+// and files in directories that match it.
+// Without an all: prefix, a directory's hidden files (starting with . or _)
+// are not included, as in cmd/go's resolveEmbed.
+func embedMatches(pattern string, files []string) []string {
+	glob, all := strings.CutPrefix(pattern, "all:")
+	var match []string
+	for _, file := range files {
+		if ok, _ := path.Match(glob, file); ok {
+			match = append(match, file)
+			continue
+		}
+		for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(glob, dir); !ok {
+				continue
+			}
+			if all || !hiddenPath(strings.TrimPrefix(file, dir+"/")) {
+				match = append(match, file)
+			}
+			break
+		}
+	}
+	return match
+}
+
+// hiddenPath reports whether any element of the slash-separated path p
+// starts with . or _.
+func hiddenPath(p string) bool {
+	for _, elem := range strings.Split(p, "/") {
+		if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	compileJobsMu sync.Mutex
+	compileJobs   = map[string]*compileJob{} // GOROOT, platform, env, flags, and package -> job
+)
+
+// compileJob returns the job for compiling pkg with c's toolchain for platform.
+// It builds pkg's dependencies (via go list -export), once per process,
+// and they are not measured.
+func (c *commit) compileJob(platform, pkg string) *compileJob {
+	key := strings.Join([]string{c.dir, platform, envKey(c.env), c.flags.key(), pkg}, "\x00")
+	compileJobsMu.Lock()
+	defer compileJobsMu.Unlock()
+	if job, ok := compileJobs[key]; ok {
+		return job
+	}
+	env := c.environ(platform)
+	cmdgo := filepath.Join(c.dir, "bin", "go")
+	// Packages outside GOROOT are resolved relative to the user's working directory.
+	dir := filepath.Join(c.dir, "src")
+	if *flagPkg != "" {
+		dir = cwd
+	}
+	golist := func(args ...string) []byte {
+		cmd := exec.Command(cmdgo, append([]string{"list"}, args...)...)
+		cmd.Env = env
+		cmd.Dir = dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			log.Fatalf("%s\n%s: %v", stderr.Bytes(), cmd, err)
+		}
+		return out
+	}
+
+	var p listedPackage
+	if err := json.Unmarshal(golist("-json", pkg), &p); err != nil {
+		log.Fatalf("unexpected go list output for %s: %v", pkg, err)
+	}
+	job, err := newCompileJob(&p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The dependencies are built with the same flags as the measured package.
+	listArgs := append([]string{"-export", "-deps"}, c.flags.args("")...)
+	job.importcfg = golist(append(listArgs, "-f",
+		`{{if eq .ImportPath "`+pkg+`"}}{{range $k, $v := .ImportMap}}importmap {{$k}}={{$v}}{{"\n"}}{{end}}`+
+			`{{else if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}`, pkg)...)
+	if len(job.sfiles) > 0 {
+		_, goarch := parsePlatform(platform)
+		if v, ok := archVariants[goarch]; ok {
+			// go env reports the default when the setting is unset.
+			cmd := exec.Command(cmdgo, "env", v.env)
+			cmd.Env = env
+			out, err := cmd.Output()
+			check(err)
+			job.asmDefines = asmDefines(goarch, strings.TrimSpace(string(out)))
+		}
+	}
+	compileJobs[key] = job
+	return job
+}
+
+// forgetCompileJobs forgets the compile jobs,
+// whose export data is gone once the go cache is cleaned.
+func forgetCompileJobs() {
+	compileJobsMu.Lock()
+	defer compileJobsMu.Unlock()
+	clear(compileJobs)
+}
+
+// asmDefines returns the assembler flags that define macros for goarch's
+// micro-architecture setting value, such as GOAMD64_v3 for v3,
+// as cmd/go does (see asmArgs in cmd/go/internal/work/gc.go).
+func asmDefines(goarch, value string) []string {
+	if value == "" {
+		return nil
+	}
+	var defs []string
+	switch goarch {
+	case "386":
+		defs = []string{"GO386_" + value}
+	case "amd64":
+		defs = []string{"GOAMD64_" + value}
+	case "mips", "mipsle":
+		defs = []string{"GOMIPS_" + value}
+	case "mips64", "mips64le":
+		defs = []string{"GOMIPS64_" + value}
+	case "riscv64":
+		defs = []string{"GORISCV64_" + value}
+	case "ppc64", "ppc64le":
+		// Each level implies the ones below it.
+		defs = []string{"GOPPC64_power8"}
+		if value == "power9" || value == "power10" {
+			defs = append(defs, "GOPPC64_power9")
+		}
+		if value == "power10" {
+			defs = append(defs, "GOPPC64_power10")
+		}
+	case "arm":
+		// GOARM is a version, optionally followed by a floating point mode, as in 7,softfloat.
+		defs = []string{"GOARM_5"}
+		if strings.Contains(value, "6") || strings.Contains(value, "7") {
+			defs = append(defs, "GOARM_6")
+		}
+		if strings.Contains(value, "7") {
+			defs = append(defs, "GOARM_7")
+		}
+	case "arm64":
+		// GOARM64 is a version, optionally followed by options, as in v8.0,lse.
+		// Large System Extensions are part of v8.1 and later.
+		version, opts, _ := strings.Cut(value, ",")
+		var major, minor int
+		fmt.Sscanf(version, "v%d.%d", &major, &minor)
+		if major > 8 || major == 8 && minor >= 1 || strings.Contains(","+opts+",", ",lse,") {
+			defs = []string{"GOARM64_LSE"}
+		}
+	}
+	var args []string
+	for _, d := range defs {
+		args = append(args, "-D", d)
+	}
+	return args
+}
+
+// benchCompile measures a single invocation of the compiler on pkg.
+func (c *commit) benchCompile(platform, pkg string) measurement {
+	goos, goarch := parsePlatform(platform)
+	job := c.compileJob(platform, pkg)
+	env := c.environ(platform)
+
+	tmp, err := os.MkdirTemp("", "compilecmp-bench-")
+	check(err)
+	defer os.RemoveAll(tmp)
+	cfg := filepath.Join(tmp, "importcfg")
+	check(os.WriteFile(cfg, job.importcfg, 0644))
+	obj := filepath.Join(tmp, "_pkg_.a")
+
+	args := []string{"-o", obj, "-p", pkg, "-importcfg", cfg, "-pack"}
+	if job.lang != "" {
+		args = append(args, "-lang="+job.lang)
+	}
+	if job.std {
+		args = append(args, "-std")
+	}
+	if job.complete {
+		args = append(args, "-complete")
+	}
+	if job.embedcfg != nil {
+		embedcfg := filepath.Join(tmp, "embedcfg")
+		check(os.WriteFile(embedcfg, job.embedcfg, 0644))
+		args = append(args, "-embedcfg", embedcfg)
+	}
+	if len(job.sfiles) > 0 {
+		// The compiler needs to know the ABIs of assembly functions.
+		// As in cmd/go, generate them with an empty go_asm.h.
+		check(os.WriteFile(filepath.Join(tmp, "go_asm.h"), nil, 0644))
+		symabis := filepath.Join(tmp, "symabis")
+		asm := []string{"-p", pkg, "-I", tmp, "-I", filepath.Join(c.dir, "pkg", "include"),
+			"-D", "GOOS_" + goos, "-D", "GOARCH_" + goarch}
+		asm = append(asm, job.asmDefines...)
+		asm = append(asm, strings.Fields(c.flags.asm)...)
+		asm = append(asm, "-gensymabis", "-o", symabis)
+		asm = append(asm, job.sfiles...)
+		cmd := exec.Command(c.toolPath("asm"), asm...)
+		cmd.Env = env
+		cmd.Dir = job.dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Fatalf("%s\n%s: %v", out, cmd, err)
+		}
+		args = append(args, "-symabis", symabis)
+	}
+	args = append(args, strings.Fields(c.flags.gc)...)
+	args = append(args, job.files...)
+	cmd := exec.Command(c.toolPath("compile"), args...)
+	cmd.Env = env
+	cmd.Dir = job.dir
+	m := measure(cmd)
+	m.objSize = filesize(obj)
+	return m
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectedBenchmarks(t *testing.T) {
+	defer func(run, pkg string, all bool) { *flagRun, *flagPkg, *flagAll = run, pkg, all }(*flagRun, *flagPkg, *flagAll)
+	names := func() []string {
+		var names []string
+		for _, b := range selectedBenchmarks() {
+			names = append(names, b.name)
+		}
+		return names
+	}
+
+	*flagRun, *flagPkg, *flagAll = "", "", false
+	got := names()
+	for _, name := range got {
+		if name == "SSA" || name == "StdCmd" {
+			t.Errorf("default benchmarks include long benchmark %s", name)
+		}
+	}
+	if len(got) != len(compileBenchmarks)-2 {
+		t.Errorf("default benchmarks = %v, want all but the long ones", got)
+	}
+
+	*flagAll = true
+	if got := names(); len(got) != len(compileBenchmarks) {
+		t.Errorf("-all benchmarks = %v, want all", got)
+	}
+
+	*flagRun = "^(Go|SSA)"
+	if got, want := names(), []string{"GoTypes", "SSA", "GoParser"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("-all -run benchmarks = %v, want %v", got, want)
+	}
+	*flagAll = false
+	if got, want := names(), []string{"GoTypes", "GoParser"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("-run benchmarks = %v, want %v", got, want)
+	}
+
+	*flagPkg = "example.com/x"
+	if got := selectedBenchmarks(); len(got) != 1 || got[0].name != "Pkg/example.com/x" || got[0].pkg != "example.com/x" {
+		t.Errorf("-pkg benchmarks = %+v, want just example.com/x", got)
+	}
+}
+
+func TestMeasurementWrite(t *testing.T) {
+	defer func(cpu, obj bool) { *flagCPU, *flagObj = cpu, obj }(*flagCPU, *flagObj)
+	m := measurement{wall: 3 * time.Second, user: 2 * time.Second, sys: time.Second, peakRSS: 1000, objSize: 500}
+	for _, tt := range []struct {
+		cpu, obj bool
+		want     string
+	}{
+		{false, false, "BenchmarkTemplate 1 3000000000 ns/op 2000000000 user-ns/op 1000000000 sys-ns/op 1000 peak-RSS-bytes\n"},
+		{true, false, "BenchmarkTemplate 1 3000000000 ns/op 2000000000 user-ns/op 1000000000 sys-ns/op\n"},
+		{false, true, "BenchmarkTemplate 1 3000000000 ns/op 2000000000 user-ns/op 1000000000 sys-ns/op 1000 peak-RSS-bytes 500 obj-bytes\n"},
+	} {
+		*flagCPU, *flagObj = tt.cpu, tt.obj
+		var buf strings.Builder
+		m.write(&buf, "Template")
+		if buf.String() != tt.want {
+			t.Errorf("-cpu=%v -obj=%v: write = %q, want %q", tt.cpu, tt.obj, buf.String(), tt.want)
+		}
+		// The output must be readable by the benchmark parser.
+		if name, _, ok := filterBenchLine(strings.TrimSuffix(tt.want, "\n")); !ok || name != "Template" {
+			t.Errorf("-cpu=%v -obj=%v: filterBenchLine = %q, %v, want Template, true", tt.cpu, tt.obj, name, ok)
+		}
+	}
+}
+
+func TestAsmDefines(t *testing.T) {
+	for _, tt := range []struct {
+		goarch, value string
+		want          string
+	}{
+		{"amd64", "v3", "-D GOAMD64_v3"},
+		{"386", "softfloat", "-D GO386_softfloat"},
+		{"arm", "6", "-D GOARM_5 -D GOARM_6"},
+		{"arm", "7,softfloat", "-D GOARM_5 -D GOARM_6 -D GOARM_7"},
+		{"arm64", "v8.0", ""},
+		{"arm64", "v8.0,lse", "-D GOARM64_LSE"},
+		{"arm64", "v9.0", "-D GOARM64_LSE"},
+		{"mipsle", "softfloat", "-D GOMIPS_softfloat"},
+		{"mips64", "hardfloat", "-D GOMIPS64_hardfloat"},
+		{"ppc64le", "power9", "-D GOPPC64_power8 -D GOPPC64_power9"},
+		{"riscv64", "rva22u64", "-D GORISCV64_rva22u64"},
+		{"amd64", "", ""},
+	} {
+		if got := strings.Join(asmDefines(tt.goarch, tt.value), " "); got != tt.want {
+			t.Errorf("asmDefines(%s, %q) = %q, want %q", tt.goarch, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewCompileJob(t *testing.T) {
+	p := &listedPackage{
+		Dir:           "/src/x",
+		ImportPath:    "example.com/x",
+		Module:        &struct{ GoVersion string }{"1.22.3"},
+		GoFiles:       []string{"x.go"},
+		EmbedPatterns: []string{"static", "all:static", "*.txt"},
+		EmbedFiles:    []string{"a.txt", "static/.h/b.txt", "static/c.txt", "static/d/_e.txt"},
+	}
+	job, err := newCompileJob(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.lang != "go1.22" || !job.complete || job.std {
+		t.Errorf("lang = %q, complete = %v, std = %v; want go1.22, true, false", job.lang, job.complete, job.std)
+	}
+	var cfg struct {
+		Patterns map[string][]string
+		Files    map[string]string
+	}
+	if err := json.Unmarshal(job.embedcfg, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	wantPatterns := map[string][]string{
+		"static":     {"static/c.txt"},
+		"all:static": {"static/.h/b.txt", "static/c.txt", "static/d/_e.txt"},
+		"*.txt":      {"a.txt"},
+	}
+	if !reflect.DeepEqual(cfg.Patterns, wantPatterns) {
+		t.Errorf("embedcfg patterns = %v, want %v", cfg.Patterns, wantPatterns)
+	}
+	if got := cfg.Files["static/c.txt"]; got != filepath.Join("/src/x", "static/c.txt") {
+		t.Errorf("embedcfg file static/c.txt = %q", got)
+	}
+
+	// Standard packages get the toolchain's -lang, and some are never complete.
+	job, err = newCompileJob(&listedPackage{ImportPath: "os", Standard: true, GoFiles: []string{"file.go"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.lang != "" || job.complete || job.embedcfg != nil {
+		t.Errorf("os: lang = %q, complete = %v, embedcfg = %q; want none", job.lang, job.complete, job.embedcfg)
+	}
+	job, err = newCompileJob(&listedPackage{ImportPath: "example.com/y", Module: &struct{ GoVersion string }{}, SFiles: []string{"y.s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.lang != "go1.16" || job.complete {
+		t.Errorf("package with assembly: lang = %q, complete = %v; want go1.16, false", job.lang, job.complete)
+	}
+
+	if _, err := newCompileJob(&listedPackage{ImportPath: "os/user", CgoFiles: []string{"cgo_lookup_cgo.go"}}); err == nil {
+		t.Errorf("newCompileJob of a cgo package succeeded")
+	}
+}
+
+func TestLangVersion(t *testing.T) {
+	for v, want := range map[string]string{"1.21": "1.21", "1.21.3": "1.21", "1.22rc1": "1.22", "1": "1"} {
+		if got := langVersion(v); got != want {
+			t.Errorf("langVersion(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchResults holds samples parsed from Go benchmark format output,
+// keyed by benchmark name (without the "Benchmark" prefix) and then by unit.
+type benchResults struct {
+	names   []string // in order of first appearance
+	samples map[string]map[string][]float64
+	units   map[string][]string // per benchmark, in order of first appearance
+}
+
+// parseBench parses Go benchmark format output, such as that written by compilebench.
+// Lines that are not benchmark results are ignored.
+func parseBench(r io.Reader) (*benchResults, error) {
+	res := &benchResults{
+		samples: make(map[string]map[string][]float64),
+		units:   make(map[string][]string),
+	}
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		f := strings.Fields(scan.Text())
+		// BenchmarkName iters value unit [value unit...]
+		if len(f) < 4 || len(f)%2 != 0 || !strings.HasPrefix(f[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(f[1]); err != nil {
+			continue
+		}
+		name := strings.TrimPrefix(f[0], "Benchmark")
+		for i := 2; i < len(f); i += 2 {
+			v, err := strconv.ParseFloat(f[i], 64)
+			if err != nil {
+				break
+			}
+			res.add(name, f[i+1], v)
+		}
+	}
+	return res, scan.Err()
+}
+
+func parseBenchFile(path string) *benchResults {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	res, err := parseBench(f)
+	check(err)
+	return res
+}
+
+func (r *benchResults) add(name, unit string, v float64) {
+	byUnit, ok := r.samples[name]
+	if !ok {
+		byUnit = make(map[string][]float64)
+		r.samples[name] = byUnit
+		r.names = append(r.names, name)
+	}
+	if _, ok := byUnit[unit]; !ok {
+		r.units[name] = append(r.units[name], unit)
+	}
+	byUnit[unit] = append(byUnit[unit], v)
+}
+
+// benchRows pairs up before and after samples for every benchmark and unit
+// present in both, and compares them.
+func benchRows(before, after *benchResults) []benchRow {
+	var rows []benchRow
+	for _, name := range before.names {
+		for _, unit := range before.units[name] {
+			a := before.samples[name][unit]
+			b := after.samples[name][unit]
+			if len(b) == 0 {
+				continue
+			}
+			row := benchRow{
+				Name:         name,
+				Unit:         unit,
+				Before:       a,
+				After:        b,
+				BeforeMedian: median(a),
+				AfterMedian:  median(b),
+			}
+			if ci, ok := medianCI(a, confidence); ok {
+				row.BeforeCI = &ci
+			}
+			if ci, ok := medianCI(b, confidence); ok {
+				row.AfterCI = &ci
+			}
+			if row.BeforeMedian != 0 {
+				row.Delta = 100*row.AfterMedian/row.BeforeMedian - 100
+			}
+			row.P = mannWhitneyU(a, b)
+			row.Significant = row.P < alpha
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// printBenchRows prints rows as a table per unit, in the style of benchstat.
+// Changes that are not statistically significant are shown as "~".
+func printBenchRows(w io.Writer, rows []benchRow) {
+	var units []string
+	byUnit := make(map[string][]benchRow)
+	for _, row := range rows {
+		if _, ok := byUnit[row.Unit]; !ok {
+			units = append(units, row.Unit)
+		}
+		byUnit[row.Unit] = append(byUnit[row.Unit], row)
+	}
+	for i, unit := range units {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "name\tbefore %[1]s\tafter %[1]s\tΔ\t\n", unit)
+		var beforeMedians, afterMedians []float64
+		for _, row := range byUnit[unit] {
+			delta := "~"
+			if row.Significant {
+				delta = fmt.Sprintf("%+.2f%%", row.Delta)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t(p=%.3f n=%d+%d)\n", row.Name,
+				formatSummary(row.BeforeMedian, row.BeforeCI, unit),
+				formatSummary(row.AfterMedian, row.AfterCI, unit),
+				delta, row.P, len(row.Before), len(row.After))
+			beforeMedians = append(beforeMedians, row.BeforeMedian)
+			afterMedians = append(afterMedians, row.AfterMedian)
+		}
+		if len(byUnit[unit]) > 1 {
+			b, a := geomean(beforeMedians), geomean(afterMedians)
+			if b != 0 {
+				fmt.Fprintf(tw, "[geomean]\t%s\t%s\t%+.2f%%\t\n", formatValue(b, unit), formatValue(a, unit), 100*a/b-100)
+			}
+		}
+		tw.Flush()
+	}
+}
+
+// formatSummary formats a median and the relative width of its confidence interval.
+func formatSummary(median float64, ci *interval, unit string) string {
+	s := formatValue(median, unit)
+	if ci == nil {
+		return s + " ± ∞"
+	}
+	if median == 0 {
+		return s
+	}
+	spread := math.Max(ci.Hi-median, median-ci.Lo) / math.Abs(median)
+	return fmt.Sprintf("%s ±%2.0f%%", s, 100*spread)
+}
+
+// formatValue formats v, measured in unit, with a scale suffix.
+func formatValue(v float64, unit string) string {
+	if strings.HasSuffix(unit, "ns/op") {
+		d := time.Duration(v)
+		switch {
+		case d >= time.Second:
+			return fmt.Sprintf("%.2fs", d.Seconds())
+		case d >= time.Millisecond:
+			return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+		case d >= time.Microsecond:
+			return fmt.Sprintf("%.2fµs", float64(d)/float64(time.Microsecond))
+		}
+		return fmt.Sprintf("%.0fns", v)
+	}
+	switch a := math.Abs(v); {
+	case a >= 1e9:
+		return fmt.Sprintf("%.2fG", v/1e9)
+	case a >= 1e6:
+		return fmt.Sprintf("%.2fM", v/1e6)
+	case a >= 1e3:
+		return fmt.Sprintf("%.2fk", v/1e3)
+	}
+	return fmt.Sprintf("%.4g", v)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBench(t *testing.T) {
+	const in = `goos: linux
+goarch: amd64
+BenchmarkTemplate 1 200 ns/op 10 B/op
+BenchmarkUnicode 1 50 ns/op
+BenchmarkTemplate 1 100 ns/op 30 B/op
+PASS
+`
+	res, err := parseBench(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Template", "Unicode"}; !reflect.DeepEqual(res.names, want) {
+		t.Errorf("names = %v, want %v", res.names, want)
+	}
+	if got, want := res.samples["Template"]["ns/op"], []float64{200, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Template ns/op = %v, want %v", got, want)
+	}
+	if got, want := res.units["Template"], []string{"ns/op", "B/op"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Template units = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A bisectCond is a condition for -bisect to search for.
+// Its syntax is kind:name[op threshold[%]], where kind is one of
+//
+//	size   the total size of the binaries named name (e.g. compile)
+//	fn     the total text size of the functions named name (e.g. ssa.rewriteValueAMD64; see funcMatches)
+//	bench  the median ns/op of the benchmark named name (e.g. Template)
+//
+// op is > or <, and the condition holds at a commit when the metric's change
+// from the good commit is greater or less than threshold, measured in bytes,
+// nanoseconds, or (with a % suffix) percent. Without an op, the condition
+// holds when the metric changed at all.
+type bisectCond struct {
+	kind      string
+	name      string
+	op        byte // '>', '<', or 0 for any change
+	threshold float64
+	pct       bool
+}
+
+func parseBisectCond(s string) (bisectCond, error) {
+	var c bisectCond
+	kind, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return c, fmt.Errorf("bad -bisect condition %q: want kind:name[>N|<N]", s)
+	}
+	switch kind {
+	case "size", "fn", "bench":
+	default:
+		return c, fmt.Errorf("bad -bisect condition %q: unknown kind %q (want size, fn, or bench)", s, kind)
+	}
+	c.kind = kind
+	c.name = rest
+	if i := strings.IndexAny(rest, "<>"); i >= 0 {
+		c.name = rest[:i]
+		c.op = rest[i]
+		n := rest[i+1:]
+		if strings.HasSuffix(n, "%") {
+			c.pct = true
+			n = strings.TrimSuffix(n, "%")
+		}
+		t, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return c, fmt.Errorf("bad -bisect condition %q: bad threshold: %v", s, err)
+		}
+		c.threshold = t
+	}
+	if c.name == "" {
+		return c, fmt.Errorf("bad -bisect condition %q: missing name", s)
+	}
+	return c, nil
+}
+
+// holds reports whether the condition holds for a metric
+// that changed from good to v.
+func (c bisectCond) holds(good, v float64) bool {
+	delta := v - good
+	if c.pct {
+		if good == 0 {
+			return v != 0
+		}
+		delta = 100 * delta / good
+	}
+	switch c.op {
+	case '>':
+		return delta > c.threshold
+	case '<':
+		return delta < c.threshold
+	}
+	return delta != 0
+}
+
+// measure returns the metric for c at commit ref, on platform.
+// found reports whether ref has anything named c.name to measure.
+func (c bisectCond) measure(platform, ref string) (v float64, found bool) {
+	wt := worktree(ref)
+	// Only benchmarks write to the temp file, and close it to read it back.
+	defer os.Remove(wt.tmp.Name())
+	if c.kind != "bench" {
+		check(wt.tmp.Close())
+	}
+	wt.env = envSettings(*flagEnv)
+	wt.flags = buildFlags{
+		gc:  combineFlags(*flagFlags, ""),
+		ld:  combineFlags(*flagLdflags, ""),
+		asm: combineFlags(*flagAsmflags, ""),
+	}
+	switch c.kind {
+	case "size":
+		sizes, _, _ := binariesFor(platform, wt)
+		var size int64
+		for _, dir := range binaryDirs(platform) {
+			n, ok := sizes[dir+"/"+c.name]
+			size += n
+			found = found || ok
+		}
+		return float64(size), found
+	case "fn":
+		size, found := funcSize(funcsFor(platform, wt), c.name)
+		return float64(size), found
+	case "bench":
+		n := *flagCount
+		if n == 0 {
+			n = 5
+		}
+		runBenchmarks(platform, []*commit{&wt}, n)
+		fmt.Fprintln(progress)
+		check(wt.tmp.Close())
+		samples := parseBenchFile(wt.tmp.Name()).samples[c.name]["ns/op"]
+		if len(samples) == 0 {
+			log.Fatalf("-bisect: no results for benchmark %s; check -run, -all, and -pkg", c.name)
+		}
+		return median(samples), true
+	}
+	panic("unreachable")
+}
+
+// format formats the metric v for c, as the other tables do:
+// sizes in bytes, and benchmark medians with a scale suffix.
+func (c bisectCond) format(v float64) string {
+	if c.kind == "bench" {
+		return formatValue(v, "ns/op")
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+// funcSize returns the total text size of the functions in pkgs that match name
+// (see funcMatches), and whether there are any.
+func funcSize(pkgs map[string]map[string]stextFunc, name string) (size int, found bool) {
+	for pkg, funcs := range pkgs {
+		for fn, f := range funcs {
+			if funcMatches(pkg, fn, name) {
+				size += f.textsize
+				found = true
+			}
+		}
+	}
+	return size, found
+}
+
+// funcMatches reports whether the function fn, compiled in package pkg, is named name.
+// name may be qualified by the function's import path (cmd/compile/internal/ssa.rewriteValueAMD64),
+// by a suffix of it (ssa.rewriteValueAMD64), or not at all (rewriteValueAMD64).
+func funcMatches(pkg, fn, name string) bool {
+	full := cleanFuncName(fn)
+	if !strings.HasPrefix(full, pkg+".") {
+		full = pkg + "." + full
+	}
+	return full == name || strings.HasSuffix(full, "/"+name) || full == pkg+"."+name
+}
+
+// bisect finds the first commit between goodRef and badRef at which cond holds,
+// compared to goodRef.
+func bisect(platform, goodRef, badRef string, cond bisectCond) {
+	list, err := git("rev-list", "--reverse", "--ancestry-path", goodRef+".."+badRef)
+	if err != nil {
+		log.Fatalf("could not list commits from %s to %s: %v", goodRef, badRef, err)
+	}
+	revs := strings.Fields(string(list))
+	if len(revs) == 0 {
+		log.Fatalf("%s is not an ancestor of %s", goodRef, badRef)
+	}
+
+	fmt.Fprintf(stdout, "compilecmp -bisect %s %s..%s (%d commits)\n", cond.kind+":"+cond.name, goodRef, badRef, len(revs))
+	if platform != "" {
+		fmt.Fprintf(stdout, "platform: %s\n", platform)
+	}
+	show := func(ref string, v float64, bad bool) {
+		verdict := "good"
+		if bad {
+			verdict = "bad"
+		}
+		fmt.Fprintf(stdout, "%s %-4s %s: %s\n", shortsha(resolve(ref)), verdict, cond.format(v), commitmessage(ref))
+	}
+	good, found := cond.measure(platform, goodRef)
+	if !found {
+		log.Fatalf("-bisect: nothing named %s at %s; check the %s name", cond.name, goodRef, cond.kind)
+	}
+	show(goodRef, good, false)
+	v, _ := cond.measure(platform, badRef)
+	if !cond.holds(good, v) {
+		show(badRef, v, false)
+		log.Fatalf("condition does not hold at %s", badRef)
+	}
+	show(badRef, v, true)
+
+	// revs[lo] is good (or lo is -1, for goodRef), and revs[hi] is bad.
+	lo, hi := -1, len(revs)-1
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		v, _ := cond.measure(platform, revs[mid])
+		bad := cond.holds(good, v)
+		show(revs[mid], v, bad)
+		if bad {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	fmt.Fprintln(stdout)
+	fmt.Fprint(stdout, "first bad commit: ")
+	printcommit(revs[hi])
+}
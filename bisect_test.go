@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestParseBisectCond(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want bisectCond
+	}{
+		{"size:compile>1000", bisectCond{kind: "size", name: "compile", op: '>', threshold: 1000}},
+		{"fn:ssa.rewriteValueAMD64", bisectCond{kind: "fn", name: "ssa.rewriteValueAMD64"}},
+		{"bench:Template>5%", bisectCond{kind: "bench", name: "Template", op: '>', threshold: 5, pct: true}},
+		{"size:link<-1.5%", bisectCond{kind: "size", name: "link", op: '<', threshold: -1.5, pct: true}},
+	} {
+		got, err := parseBisectCond(tt.in)
+		if err != nil {
+			t.Errorf("parseBisectCond(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBisectCond(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"compile>1000", "speed:Template", "size:>10", "bench:Template>fast"} {
+		if _, err := parseBisectCond(in); err == nil {
+			t.Errorf("parseBisectCond(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestBisectCondHolds(t *testing.T) {
+	for _, tt := range []struct {
+		cond    string
+		good, v float64
+		want    bool
+	}{
+		{"size:compile>1000", 5000, 6000, false},
+		{"size:compile>1000", 5000, 6001, true},
+		{"size:compile<-10", 5000, 4980, true},
+		{"fn:f", 100, 100, false},
+		{"fn:f", 100, 96, true},
+		{"bench:Template>5%", 100, 104, false},
+		{"bench:Template>5%", 100, 106, true},
+	} {
+		c, err := parseBisectCond(tt.cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.holds(tt.good, tt.v); got != tt.want {
+			t.Errorf("%s holds(%v, %v) = %v, want %v", tt.cond, tt.good, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFuncSize(t *testing.T) {
+	pkgs := map[string]map[string]stextFunc{
+		"cmd/compile/internal/ssa": {
+			"cmd/compile/internal/ssa.rewriteValueAMD64":   {textsize: 100},
+			"cmd/compile/internal/ssa.rewriteValueAMD64_1": {textsize: 10},
+			"cmd/compile/internal/ssa.(*Func).Log":         {textsize: 5},
+		},
+		"go/ssa": {
+			`"".rewriteValueAMD64`: {textsize: 1}, // old-style package-relative name
+		},
+	}
+	for _, tt := range []struct {
+		name  string
+		size  int
+		found bool
+	}{
+		{"ssa.rewriteValueAMD64", 101, true},
+		{"cmd/compile/internal/ssa.rewriteValueAMD64", 100, true},
+		{"internal/ssa.rewriteValueAMD64", 100, true},
+		{"rewriteValueAMD64", 101, true},
+		{"ssa.(*Func).Log", 5, true},
+		{"al/ssa.rewriteValueAMD64", 0, false},
+		{"ssa.rewriteValue", 0, false},
+	} {
+		size, found := funcSize(pkgs, tt.name)
+		if size != tt.size || found != tt.found {
+			t.Errorf("funcSize(%q) = %d, %v, want %d, %v", tt.name, size, found, tt.size, tt.found)
+		}
+	}
+}
+
+func TestBisectCondFormat(t *testing.T) {
+	if got := (bisectCond{kind: "size"}).format(14578257); got != "14578257" {
+		t.Errorf("size format = %q, want 14578257", got)
+	}
+	if got := (bisectCond{kind: "bench"}).format(1.5e9); got != "1.50s" {
+		t.Errorf("bench format = %q, want 1.50s", got)
+	}
+}
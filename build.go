@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildWorktrees builds the worktrees for refs concurrently,
+// up to -j at a time, so that later calls to worktree are fast.
+func buildWorktrees(refs ...string) {
+	buildEach(refs, *flagJobs, resolve, func(ref string) { buildWorktree(ref) })
+}
+
+// buildEach calls build once for each distinct sha among refs,
+// as resolved by resolve, running up to jobs builds at once.
+func buildEach(refs []string, jobs int, resolve func(ref string) string, build func(ref string)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan bool, jobs)
+	var wg sync.WaitGroup
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		sha := resolve(ref)
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		wg.Add(1)
+		go func(ref string) {
+			defer wg.Done()
+			sem <- true
+			build(ref)
+			<-sem
+		}(ref)
+	}
+	wg.Wait()
+}
+
+// progressMu serializes progress output from concurrent builds.
+var progressMu sync.Mutex
+
+func buildProgress(format string, args ...any) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	fmt.Fprintf(progress, format+"\n", args...)
+}
+
+// runBuild runs cmd, a toolchain build for the worktree with the given label.
+// For make.bash and all.bash, it reports their progress,
+// which are the lines that start a new phase of the build.
+func runBuild(cmd *exec.Cmd, label string) {
+	var out bytes.Buffer
+	script := strings.HasSuffix(cmd.Path, ".bash")
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	scanned := make(chan bool)
+	go func() {
+		scan := bufio.NewScanner(pr)
+		for scan.Scan() {
+			line := scan.Text()
+			out.WriteString(line + "\n")
+			if script && (strings.HasPrefix(line, "Building ") || strings.HasPrefix(line, "##### ")) {
+				buildProgress("%s: %s", label, line)
+			}
+		}
+		io.Copy(io.Discard, pr)
+		close(scanned)
+	}()
+	start := time.Now()
+	if script {
+		buildProgress("%s: running %s", label, filepath.Base(cmd.Path))
+	}
+	err := cmd.Run()
+	pw.Close()
+	<-scanned
+	if err != nil {
+		log.Fatalf("%s\n%v", out.Bytes(), err)
+	}
+	if script {
+		buildProgress("%s: built in %v", label, time.Since(start).Round(time.Second))
+	}
+}
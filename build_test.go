@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuildEach(t *testing.T) {
+	// Refs a and A, and b and B, name the same commits.
+	refs := []string{"a", "b", "A", "c", "d", "B", "e"}
+	resolve := func(ref string) string { return strings.ToLower(ref) }
+	for _, jobs := range []int{0, 1, 2, 5} {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		built := make(map[string]int)
+		build := func(ref string) {
+			mu.Lock()
+			built[resolve(ref)]++
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+		buildEach(refs, jobs, resolve, build)
+		want := map[string]int{"a": 1, "b": 1, "c": 1, "d": 1, "e": 1}
+		if !reflect.DeepEqual(built, want) {
+			t.Errorf("-j %d: built %v, want each sha once", jobs, built)
+		}
+		if limit := max(jobs, 1); maxRunning > limit {
+			t.Errorf("-j %d: %d builds ran at once", jobs, maxRunning)
+		}
+	}
+}
+
+func TestRunBuildProgress(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	defer func(old io.Writer) { progress = old }(progress)
+	var buf strings.Builder
+	progress = &buf
+	script := filepath.Join(t.TempDir(), "make.bash")
+	body := "#!/bin/sh\n" +
+		"echo 'Building Go cmd/dist using /usr/lib/go.'\n" +
+		"echo 'cmd/dist: some detail'\n" +
+		"echo '##### Test execution environment.' >&2\n" +
+		"echo 'Installed Go for linux/amd64 in /tmp/go'\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	runBuild(exec.Command(script), "abc1234")
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{
+		"abc1234: running make.bash",
+		"abc1234: Building Go cmd/dist using /usr/lib/go.",
+		"abc1234: ##### Test execution environment.",
+	}
+	if len(lines) != len(want)+1 || !reflect.DeepEqual(lines[:len(want)], want) || !strings.HasPrefix(lines[len(want)], "abc1234: built in ") {
+		t.Errorf("progress:\n%s\nwant:\n%s\nabc1234: built in ...", buf.String(), strings.Join(want, "\n"))
+	}
+
+	// Other commands don't report progress.
+	buf.Reset()
+	runBuild(exec.Command("sh", "-c", "echo 'Building Go toolchain1'"), "abc1234")
+	if buf.Len() != 0 {
+		t.Errorf("progress for go install = %q, want none", buf.String())
+	}
+}
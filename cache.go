@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The cache can be limited by age and by size, using environment variables,
+// so that each machine can choose its own limits:
+//
+//	COMPILECMP_CACHE_MAXAGE: evict worktrees unused for longer than this duration (e.g. 720h)
+//	COMPILECMP_CACHE_MAXSIZE: evict least recently used worktrees until the cache is at most this size (e.g. 200GB)
+//
+// A worktree's stored results count towards its size, and are evicted with it.
+// Worktrees in use by the current run are never evicted.
+
+// A cacheEntry is a worktree in the cache.
+type cacheEntry struct {
+	sha      string
+	dir      string
+	lastUsed time.Time
+}
+
+// cacheEntries returns the worktrees in the cache in root,
+// least recently used first.
+func cacheEntries(root string) []cacheEntry {
+	fis, err := os.ReadDir(root)
+	check(err)
+	var entries []cacheEntry
+	for _, fi := range fis {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(root, fi.Name())
+		entries = append(entries, cacheEntry{sha: fi.Name(), dir: dir, lastUsed: lastUsed(dir)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lastUsed.Before(entries[j].lastUsed) })
+	return entries
+}
+
+// worktreeRepo returns the git directory of the repository
+// that the worktree in dir belongs to.
+// It reports false if dir is not a valid worktree.
+func worktreeRepo(dir string) (string, bool) {
+	// A worktree's .git file points to its private git directory,
+	// which contains a pointer to the repository's git directory.
+	b, err := os.ReadFile(filepath.Join(dir, ".git"))
+	if err != nil {
+		return "", false
+	}
+	gitdir, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir: ")
+	if !ok {
+		return "", false
+	}
+	if !filepath.IsAbs(gitdir) {
+		gitdir = filepath.Join(dir, gitdir)
+	}
+	b, err = os.ReadFile(filepath.Join(gitdir, "commondir"))
+	if err != nil {
+		return "", false
+	}
+	repo := strings.TrimSpace(string(b))
+	if !filepath.IsAbs(repo) {
+		repo = filepath.Join(gitdir, repo)
+	}
+	if !exists(repo) {
+		return "", false
+	}
+	return filepath.Clean(repo), true
+}
+
+// branchCommits returns the set of commits reachable from any branch
+// or snapshot in the repository with git directory repo.
+func branchCommits(repo string) map[string]bool {
+	cmd := exec.Command("git", "--git-dir="+repo, "rev-list", "--branches", "--glob="+snapshotRefs+"*")
+	out, err := cmd.Output()
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	shas := make(map[string]bool)
+	for _, sha := range strings.Fields(string(out)) {
+		shas[sha] = true
+	}
+	return shas
+}
+
+// size returns the size of the cache entry e: its worktree,
+// as recorded by markBuilt, and its stored results.
+func (e cacheEntry) size() int64 {
+	return worktreeSize(e.dir) + dirSize(filepath.Join(filepath.Dir(e.dir), resultsDir, e.sha))
+}
+
+// removeWorktree removes the worktree for sha from the cache in root,
+// along with its stored results, and prunes it from its repository,
+// so that git lets it be added again. If sha is a snapshot,
+// it deletes the snapshot's ref, so that git can collect it.
+// The caller must hold the cache lock and sha's lock.
+func removeWorktree(root, sha string) error {
+	dir := filepath.Join(root, sha)
+	repo, ok := worktreeRepo(dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(root, resultsDir, sha)); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if out, err := exec.Command("git", "--git-dir="+repo, "worktree", "prune").CombinedOutput(); err != nil {
+		return fmt.Errorf("could not prune worktrees in %s: %v\n%s", repo, err, out)
+	}
+	// Deleting a ref that doesn't exist succeeds.
+	if out, err := exec.Command("git", "--git-dir="+repo, "update-ref", "-d", snapshotRefs+sha).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not delete snapshot ref in %s: %v\n%s", repo, err, out)
+	}
+	return nil
+}
+
+// markBuilt records that the worktree in dir is fully built,
+// along with its size, so that evictCache need not walk every worktree.
+func markBuilt(dir string) {
+	size := dirSize(dir)
+	check(os.WriteFile(filepath.Join(dir, builtMarker), []byte(strconv.FormatInt(size, 10)), 0644))
+}
+
+// worktreeSize returns the size of the worktree in dir, as recorded by markBuilt.
+// Markers written by older compilecmps don't record a size;
+// for those it measures the worktree and records the size,
+// leaving the marker's last used time alone.
+func worktreeSize(dir string) int64 {
+	marker := filepath.Join(dir, builtMarker)
+	b, err := os.ReadFile(marker)
+	if err != nil {
+		// Not built (yet), so no size was recorded.
+		return dirSize(dir)
+	}
+	if size, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+		return size
+	}
+	used := lastUsed(dir)
+	size := dirSize(dir)
+	if os.WriteFile(marker, []byte(strconv.FormatInt(size, 10)), 0644) == nil {
+		os.Chtimes(marker, used, used)
+	}
+	return size
+}
+
+// touchWorktree records that the worktree in dir was just used.
+func touchWorktree(dir string) {
+	now := time.Now()
+	check(os.Chtimes(filepath.Join(dir, builtMarker), now, now))
+}
+
+// lastUsed reports when the worktree in dir was last used.
+// Worktrees that were never completely built report their creation time.
+func lastUsed(dir string) time.Time {
+	fi, err := os.Stat(filepath.Join(dir, builtMarker))
+	if err != nil {
+		fi, err = os.Stat(dir)
+		if err != nil {
+			return time.Time{}
+		}
+	}
+	return fi.ModTime()
+}
+
+// dirSize returns the total size of the regular files in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// parseSize parses a size such as "500MB" or "1.5T".
+// Suffixes are powers of 1000.
+func parseSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "B")
+	mult := 1.0
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(t, suffix) {
+			t = strings.TrimSuffix(t, suffix)
+			mult = 1
+			for j := 0; j <= i; j++ {
+				mult *= 1000
+			}
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return int64(f * mult), nil
+}
+
+// cacheLimits returns the configured cache limits; zero means no limit.
+func cacheLimits() (maxAge time.Duration, maxSize int64, err error) {
+	if s := os.Getenv("COMPILECMP_CACHE_MAXAGE"); s != "" {
+		maxAge, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad COMPILECMP_CACHE_MAXAGE: %v", err)
+		}
+	}
+	if s := os.Getenv("COMPILECMP_CACHE_MAXSIZE"); s != "" {
+		maxSize, err = parseSize(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad COMPILECMP_CACHE_MAXSIZE: %v", err)
+		}
+	}
+	return maxAge, maxSize, nil
+}
+
+// evictCache removes worktrees from the cache in root that exceed the cache limits,
+// least recently used first. It skips worktrees for shas in keep,
+// and worktrees in use by other compilecmps.
+// The caller must hold the cache lock.
+func evictCache(root string, keep map[string]bool, maxAge time.Duration, maxSize int64) {
+	if maxAge == 0 && maxSize == 0 {
+		return
+	}
+	entries := cacheEntries(root)
+	sizes := make([]int64, len(entries))
+	var total int64
+	if maxSize > 0 {
+		for i, e := range entries {
+			sizes[i] = e.size()
+			total += sizes[i]
+		}
+	}
+	for i, e := range entries {
+		tooOld := maxAge > 0 && time.Since(e.lastUsed) > maxAge
+		tooBig := maxSize > 0 && total > maxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if keep[e.sha] {
+			continue
+		}
+		l := tryLockSHA(e.sha)
+		if l == nil {
+			continue
+		}
+		if debug {
+			fmt.Fprintf(stdout, "evicting %s (last used %v)\n", e.sha, e.lastUsed.Format(time.DateTime))
+		}
+		if err := removeWorktree(root, e.sha); err != nil {
+			l.unlock()
+			fmt.Fprintf(os.Stderr, "failed to evict worktree %s: %v\n", e.sha, err)
+			continue
+		}
+		l.remove()
+		total -= sizes[i]
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"123", 123, true},
+		{"500MB", 500e6, true},
+		{"1.5T", 1.5e12, true},
+		{"200gb", 200e9, true},
+		{"10 KB", 10e3, true},
+		{"lots", 0, false},
+		{"-1G", 0, false},
+	}
+	for _, test := range cases {
+		got, err := parseSize(test.in)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("parseSize(%q)=%d, %v, want %d, ok=%v", test.in, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestEvictCache(t *testing.T) {
+	root := t.TempDir()
+	defer func(old string) { *flagCache = old }(*flagCache)
+	*flagCache = root
+	now := time.Now()
+	// Worktrees a, b, c, d were last used 4, 3, 2, and 1 days ago,
+	// and each was 100 bytes when built.
+	for i, sha := range []string{"a", "b", "c", "d"} {
+		dir := filepath.Join(root, sha)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		marker := filepath.Join(dir, builtMarker)
+		if err := os.WriteFile(marker, []byte("100"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(-time.Duration(4-i) * 24 * time.Hour)
+		if err := os.Chtimes(marker, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// c has 50 bytes of stored results, which count towards its size.
+	results := filepath.Join(root, resultsDir, "c")
+	if err := os.MkdirAll(results, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(results, "x.json"), make([]byte, 50), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remaining := func() []string {
+		var shas []string
+		for _, e := range cacheEntries(root) {
+			shas = append(shas, e.sha)
+		}
+		return shas
+	}
+
+	// a is kept, despite being too old.
+	evictCache(root, map[string]bool{"a": true}, 60*time.Hour, 0)
+	if got, want := remaining(), []string{"a", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after age eviction, cache = %v, want %v", got, want)
+	}
+	evictCache(root, nil, 0, 200)
+	if got, want := remaining(), []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after size eviction, cache = %v, want %v", got, want)
+	}
+	if exists(results) {
+		t.Errorf("stored results of evicted worktree c remain")
+	}
+}
+
+func TestWorktreeSize(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "x"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := worktreeSize(dir); got != 100 {
+		t.Errorf("unbuilt worktreeSize = %d, want 100", got)
+	}
+
+	// A marker without a size gets one, and keeps its last used time.
+	marker := filepath.Join(dir, builtMarker)
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	used := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(marker, used, used); err != nil {
+		t.Fatal(err)
+	}
+	if got := worktreeSize(dir); got != 100 {
+		t.Errorf("worktreeSize with old marker = %d, want 100", got)
+	}
+	if b, _ := os.ReadFile(marker); string(b) != "100" {
+		t.Errorf("marker = %q, want recorded size 100", b)
+	}
+	if got := lastUsed(dir); !got.Equal(used) {
+		t.Errorf("lastUsed = %v, want %v", got, used)
+	}
+
+	// Once recorded, the size is not measured again.
+	if err := os.WriteFile(filepath.Join(dir, "y"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := worktreeSize(dir); got != 100 {
+		t.Errorf("worktreeSize = %d, want recorded 100", got)
+	}
+	markBuilt(dir)
+	if got := worktreeSize(dir); got != 203 {
+		t.Errorf("after markBuilt, worktreeSize = %d, want 203", got)
+	}
+}
+
+func TestWorktreeRepo(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo", ".git")
+	gitdir := filepath.Join(repo, "worktrees", "wt")
+	wt := filepath.Join(root, "wt")
+	for _, dir := range []string{gitdir, wt} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(gitdir, "commondir"), []byte("../..\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// git writes a relative gitdir with worktree.useRelativePaths.
+	for _, path := range []string{gitdir, filepath.Join("..", "repo", ".git", "worktrees", "wt")} {
+		if err := os.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: "+path+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := worktreeRepo(wt)
+		if !ok || got != repo {
+			t.Errorf("with gitdir %s, worktreeRepo = %q, %v, want %q, true", path, got, ok, repo)
+		}
+	}
+}
+
+func TestParseCacheArgs(t *testing.T) {
+	fs := flag.NewFlagSet("compilecmp", flag.ContinueOnError)
+	dir := fs.String("cache", "", "")
+	jobs := fs.Int("j", 2, "")
+	got := parseCacheArgs(fs, []string{"-j", "4", "rm", "go1.22", "-cache", "/tmp/c", "3f2a"})
+	if want := []string{"rm", "go1.22", "3f2a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+	if *dir != "/tmp/c" || *jobs != 4 {
+		t.Errorf("-cache = %q, -j = %d, want /tmp/c, 4", *dir, *jobs)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cacheUsage = `usage: compilecmp cache <command> [flags] [args]
+
+commands:
+	list            list cached GOROOTs, most recently used first
+	size            print the total size of the cache
+	rm ref...       remove the cached GOROOTs for refs or cached sha prefixes
+	prebuild ref... build and cache GOROOTs for refs
+	verify          check that cached GOROOTs are intact`
+
+// parseCacheArgs parses the arguments that follow "compilecmp cache" with fs,
+// and returns those that are not flags.
+// Unlike fs.Parse, it allows flags after the command and its arguments,
+// as in "compilecmp cache list -cache dir".
+func parseCacheArgs(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			log.Fatal(err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return rest
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+// cacheCommand implements "compilecmp cache".
+func cacheCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal(cacheUsage)
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		cacheList()
+	case "size":
+		cacheSize()
+	case "rm":
+		cacheRemove(args)
+	case "prebuild":
+		cachePrebuild(args)
+	case "verify":
+		cacheVerify()
+	default:
+		log.Fatal(cacheUsage)
+	}
+}
+
+// entryStatus describes the state of the cache entry e.
+func entryStatus(e cacheEntry) string {
+	status := "incomplete"
+	if _, ok := worktreeRepo(e.dir); !ok {
+		status = "broken"
+	} else if exists(filepath.Join(e.dir, builtMarker)) {
+		status = "built"
+	}
+	if l := tryLockSHA(e.sha); l == nil {
+		status += ", in use"
+	} else {
+		l.unlock()
+	}
+	return status
+}
+
+// entrySubject returns the subject line of the commit for cache entry e.
+func entrySubject(e cacheEntry) string {
+	repo, ok := worktreeRepo(e.dir)
+	if !ok {
+		return "?"
+	}
+	out, err := exec.Command("git", "--git-dir="+repo, "log", "--format=%s", "-n", "1", e.sha).Output()
+	if err != nil {
+		return "?"
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+func formatBytes(n int64) string {
+	return formatValue(float64(n), "bytes") + "B"
+}
+
+func cacheList() {
+	entries := cacheEntries(cacheRoot())
+	w := tabwriter.NewWriter(stdout, 8, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "sha\tstatus\tsize\tlast used\tsubject")
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", shortsha(e.sha), entryStatus(e), formatBytes(e.size()), e.lastUsed.Format(time.DateTime), entrySubject(e))
+	}
+	w.Flush()
+}
+
+func cacheSize() {
+	root := cacheRoot()
+	entries := cacheEntries(root)
+	var total int64
+	for _, e := range entries {
+		total += worktreeSize(e.dir)
+	}
+	results := dirSize(filepath.Join(root, resultsDir))
+	fmt.Fprintf(stdout, "%s: %d GOROOTs, %s\n", root, len(entries), formatBytes(total+results))
+	if results > 0 {
+		fmt.Fprintf(stdout, "including stored results: %s\n", formatBytes(results))
+	}
+}
+
+// findCacheEntry returns the cache entry for arg,
+// which may be a prefix of a cached sha or a git ref.
+func findCacheEntry(entries []cacheEntry, arg string) (cacheEntry, bool) {
+	var match []cacheEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.sha, arg) {
+			match = append(match, e)
+		}
+	}
+	if len(match) == 1 {
+		return match[0], true
+	}
+	if len(match) > 1 {
+		log.Fatalf("%s is ambiguous: matches %d cached GOROOTs", arg, len(match))
+	}
+	out, err := git("rev-parse", "--verify", "--quiet", arg+"^{commit}")
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	sha := string(out)
+	for _, e := range entries {
+		if e.sha == sha {
+			return e, true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+func cacheRemove(args []string) {
+	if len(args) == 0 {
+		log.Fatal(cacheUsage)
+	}
+	root := cacheRoot()
+	cl := lockCache(true)
+	defer cl.unlock()
+	entries := cacheEntries(root)
+	for _, arg := range args {
+		e, ok := findCacheEntry(entries, arg)
+		if !ok {
+			log.Printf("%s is not cached", arg)
+			continue
+		}
+		l := tryLockSHA(e.sha)
+		if l == nil {
+			log.Printf("%s is in use; not removing", shortsha(e.sha))
+			continue
+		}
+		if err := removeWorktree(root, e.sha); err != nil {
+			l.unlock()
+			log.Printf("failed to remove %s: %v", e.dir, err)
+			continue
+		}
+		l.remove()
+		fmt.Fprintf(stdout, "removed %s\n", shortsha(e.sha))
+	}
+}
+
+func cachePrebuild(refs []string) {
+	if len(refs) == 0 {
+		log.Fatal(cacheUsage)
+	}
+	for _, ref := range refs {
+		resolve(ref)
+	}
+	cl := lockCache(true)
+	if _, err := git("worktree", "prune"); err != nil {
+		log.Fatalf("could not prune worktrees: %v", err)
+	}
+	cl.unlock()
+	buildWorktrees(refs...)
+	for _, ref := range refs {
+		c := worktree(ref)
+		c.tmp.Close()
+		os.Remove(c.tmp.Name())
+		fmt.Fprintf(stdout, "built %s: %s\n", shortsha(c.sha), commitmessage(c.sha))
+	}
+}
+
+func cacheVerify() {
+	problems := 0
+	for _, e := range cacheEntries(cacheRoot()) {
+		var errs []string
+		if _, ok := worktreeRepo(e.dir); !ok {
+			errs = append(errs, "not a valid git worktree")
+		} else if out, err := exec.Command("git", "-C", e.dir, "rev-parse", "HEAD").Output(); err != nil {
+			errs = append(errs, fmt.Sprintf("git rev-parse HEAD: %v", err))
+		} else if head := string(bytes.TrimSpace(out)); head != e.sha {
+			errs = append(errs, fmt.Sprintf("HEAD is %s", shortsha(head)))
+		}
+		if !exists(filepath.Join(e.dir, builtMarker)) {
+			errs = append(errs, "build incomplete")
+		} else if out, err := exec.Command(filepath.Join(e.dir, "bin", "go"), "version").CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Sprintf("go version: %v: %s", err, bytes.TrimSpace(out)))
+		}
+		if len(errs) == 0 {
+			fmt.Fprintf(stdout, "%s: ok\n", shortsha(e.sha))
+			continue
+		}
+		problems++
+		fmt.Fprintf(stdout, "%s: %s\n", shortsha(e.sha), strings.Join(errs, "; "))
+	}
+	if problems > 0 {
+		log.Fatalf("%d cached GOROOTs have problems; remove them with 'compilecmp cache rm'", problems)
+	}
+}
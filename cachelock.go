@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+)
+
+// Multiple compilecmps may share the cache, so access to it is coordinated
+// with advisory file locks:
+//
+//   - The cache lock (root/.lock) is held exclusively while pruning worktrees
+//     and cleaning the cache, and shared while adding a worktree.
+//   - Each worktree has a lock (root/SHA.lock), held exclusively while
+//     the worktree is created and first built, and shared while it is in use,
+//     which is for the rest of the process's life.
+//     A completely built worktree contains builtMarker.
+//     cleanCache skips worktrees whose lock it cannot acquire.
+//
+// The SHA locks live outside the worktrees so that deleting a worktree
+// does not delete its lock. Whoever deletes a worktree removes its lock
+// afterwards, while still holding it. Worktrees are deleted while holding
+// the cache lock, and pruned from git at once (see removeWorktree),
+// so that no compilecmp finds git still has them registered.
+
+// builtMarker is the name of a file created in a worktree
+// once its toolchain has been built successfully.
+// It holds the worktree's size, and its modification time
+// records when the worktree was last used.
+const builtMarker = ".compilecmp-built"
+
+// cacheRoot returns the root of the compilecmp cache, creating it if needed.
+// It is set by -cache or $COMPILECMP_CACHE, and defaults to ~/.compilecmp.
+func cacheRoot() string {
+	root := *flagCache
+	if root == "" {
+		root = os.Getenv("COMPILECMP_CACHE")
+	}
+	if root == "" {
+		u, err := user.Current()
+		check(err)
+		root = filepath.Join(u.HomeDir, ".compilecmp")
+	}
+	root, err := filepath.Abs(root)
+	check(err)
+	check(os.MkdirAll(root, 0755))
+	return root
+}
+
+// A fileLock is an advisory lock on a file.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile acquires a lock on the file at path, creating it if necessary.
+// If block is false and the lock is held by another process, it returns nil.
+func lockFile(path string, exclusive, block bool) *fileLock {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+		check(err)
+		ok, err := flock(f, exclusive, block)
+		if err != nil || !ok {
+			f.Close()
+			check(err)
+			return nil
+		}
+		// The file may have been removed (see fileLock.remove)
+		// while we waited for the lock, which then locks nothing.
+		// If so, try again with the file now at path.
+		fi, err := f.Stat()
+		check(err)
+		if pi, err := os.Stat(path); err == nil && os.SameFile(fi, pi) {
+			return &fileLock{f: f}
+		}
+		check(funlock(f))
+		f.Close()
+	}
+}
+
+// relock converts l to an exclusive or shared lock, blocking as needed.
+func (l *fileLock) relock(exclusive bool) {
+	_, err := flock(l.f, exclusive, true)
+	check(err)
+}
+
+func (l *fileLock) unlock() {
+	check(funlock(l.f))
+	l.f.Close()
+}
+
+// remove removes l's file, then releases l, which must be exclusive.
+func (l *fileLock) remove() {
+	// Best effort: a leftover lock file does no harm.
+	os.Remove(l.f.Name())
+	l.unlock()
+}
+
+// lockCache acquires the cache lock.
+func lockCache(exclusive bool) *fileLock {
+	return lockFile(filepath.Join(cacheRoot(), ".lock"), exclusive, true)
+}
+
+var (
+	shaLocksMu sync.Mutex
+	shaLocks   = map[string]*fileLock{} // sha -> lock held by this process
+)
+
+// lockSHA acquires a shared lock for the worktree for sha,
+// which is held until the process exits.
+// flock locks belong to the open file, not to the process,
+// so locking a second descriptor for the same sha would conflict with the first
+// (and deadlock, when one is exclusive).
+// So the locks are tracked and reused.
+func lockSHA(sha string) *fileLock {
+	shaLocksMu.Lock()
+	defer shaLocksMu.Unlock()
+	l, ok := shaLocks[sha]
+	if !ok {
+		l = lockFile(filepath.Join(cacheRoot(), sha+".lock"), false, true)
+		shaLocks[sha] = l
+	}
+	return l
+}
+
+// tryLockSHA attempts to acquire the lock for the worktree for sha exclusively,
+// without blocking. It returns nil if the worktree is in use.
+func tryLockSHA(sha string) *fileLock {
+	return lockFile(filepath.Join(cacheRoot(), sha+".lock"), true, false)
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+	l := lockFile(path, true, true)
+	if l2 := lockFile(path, false, false); l2 != nil {
+		t.Fatalf("acquired shared lock while exclusive lock held")
+	}
+	l.relock(false)
+	l2 := lockFile(path, false, false)
+	if l2 == nil {
+		t.Fatalf("failed to acquire shared lock alongside shared lock")
+	}
+	if l3 := lockFile(path, true, false); l3 != nil {
+		t.Fatalf("acquired exclusive lock while shared locks held")
+	}
+	l.unlock()
+	l2.unlock()
+	l3 := lockFile(path, true, false)
+	if l3 == nil {
+		t.Fatalf("failed to acquire exclusive lock after unlock")
+	}
+	l3.unlock()
+}
+
+func TestLockFileRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+	l := lockFile(path, true, true)
+	locked := make(chan *fileLock)
+	go func() { locked <- lockFile(path, true, true) }()
+	l.remove()
+	// The waiting lock must not end up on the removed file,
+	// or it would not exclude a new lock on path.
+	l2 := <-locked
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("lock file missing after relocking: %v", err)
+	}
+	if l3 := lockFile(path, true, false); l3 != nil {
+		t.Fatalf("acquired exclusive lock while another is held")
+	}
+	l2.remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after remove: %v", err)
+	}
+}
@@ -8,29 +8,47 @@ import (
 	"hash"
 	"io"
 	"log"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
-func compareFunctions(platform string, before, after commit) {
+func compareFunctions(platform string, before, after commit, rep *report) {
+	if *flagFn != "diff" {
+		// Use stored function sizes, if available.
+		var a map[string]map[string]stextFunc
+		done := make(chan bool)
+		go func() {
+			a = funcsFor(platform, before)
+			done <- true
+		}()
+		b := funcsFor(platform, after)
+		<-done
+		compareFuncChans(sendFuncs(a), sendFuncs(b), rep)
+		return
+	}
 	await, ascan := streamDashS(platform, before)
 	bwait, bscan := streamDashS(platform, after)
-	compareFuncReaders(ascan, bscan, before.sha, after.sha)
+	compareFuncReaders(ascan, bscan, before.sha, after.sha, rep)
 	await()
 	bwait()
 }
 
-func compareFuncReaders(a, b io.Reader, aHash, bHash string) {
-	sizesBuf := new(bytes.Buffer)
-	sizes := newFilesizes(sizesBuf)
-
+func compareFuncReaders(a, b io.Reader, aHash, bHash string, rep *report) {
 	aChan := make(chan *pkgScanner)
 	go scanDashS(a, []byte(aHash), aChan)
 	bChan := make(chan *pkgScanner)
 	go scanDashS(b, []byte(bHash), bChan)
+	compareFuncChans(aChan, bChan, rep)
+}
+
+// compareFuncChans compares the packages received on aChan and bChan,
+// which may arrive in any order.
+func compareFuncChans(aChan, bChan <-chan *pkgScanner, rep *report) {
+	sizesBuf := new(bytes.Buffer)
+	sizes := newFilesizes(sizesBuf)
+
 	aPkgs := make(map[string]*pkgScanner)
 	bPkgs := make(map[string]*pkgScanner)
 	for {
@@ -79,7 +97,7 @@ func compareFuncReaders(a, b io.Reader, aHash, bHash string) {
 			if !needsHeader {
 				return
 			}
-			fmt.Printf("\n%s%s%s%s\n", ansiFgYellow, ansiBold, pkg, ansiReset)
+			fmt.Fprintf(stdout, "\n%s%s%s%s\n", ansiFgYellow, ansiBold, pkg, ansiReset)
 			needsHeader = false
 		}
 
@@ -87,33 +105,44 @@ func compareFuncReaders(a, b io.Reader, aHash, bHash string) {
 		for name, asf := range aPkg.Funcs {
 			aTot += asf.textsize
 			bsf, ok := bPkg.Funcs[name]
+			if ok {
+				bTot += bsf.textsize
+				delete(bPkg.Funcs, name)
+			}
+			if fnRegexp != nil && !fnRegexp.MatchString(cleanFuncName(name)) {
+				continue
+			}
 			if !ok {
+				rep.Functions = append(rep.Functions, funcRecord{Package: pkg, Name: cleanFuncName(name), Change: "deleted", Before: asf.textsize})
 				if *flagFn != "stats" {
 					printHeader()
-					fmt.Println("deleted", cleanFuncName(name))
+					fmt.Fprintln(stdout, "deleted", cleanFuncName(name))
 				}
 				continue
 			}
-			delete(bPkg.Funcs, name)
-			bTot += bsf.textsize
 			if bytes.Equal(asf.bodyhash, bsf.bodyhash) {
 				continue
 			}
-			// TODO: option to show these
+			var diff string
+			if *flagFn == "diff" {
+				diff = unifiedDiff("before/"+cleanFuncName(name), "after/"+cleanFuncName(name), asf.body, bsf.body)
+			}
 			if asf.textsize == bsf.textsize {
-				if *flagFn == "all" {
+				rep.Functions = append(rep.Functions, funcRecord{Package: pkg, Name: cleanFuncName(name), Change: "changed", Before: asf.textsize, After: bsf.textsize, Diff: diff})
+				if *flagFn == "all" || *flagFn == "diff" {
 					printHeader()
-					fmt.Print(ansiFgBlue)
-					fmt.Println(name, "changed")
-					fmt.Print(ansiReset)
+					fmt.Fprint(stdout, ansiFgBlue)
+					fmt.Fprintln(stdout, name, "changed")
+					fmt.Fprint(stdout, ansiReset)
+					fmt.Fprint(stdout, diff)
 				}
-				// TODO: option for this?
-				// diff.Text("a", "b", asf.body, bsf.body, os.Stdout)
 				continue
 			}
 			color := ""
 			show := true
+			change := "shrunk"
 			if asf.textsize < bsf.textsize {
+				change = "grown"
 				if *flagFn == "smaller" || *flagFn == "stats" {
 					show = false
 				}
@@ -124,37 +153,44 @@ func compareFuncReaders(a, b io.Reader, aHash, bHash string) {
 				}
 				color = ansiFgGreen
 			}
+			rep.Functions = append(rep.Functions, funcRecord{Package: pkg, Name: cleanFuncName(name), Change: change, Before: asf.textsize, After: bsf.textsize, Diff: diff})
 			if show {
 				printHeader()
-				fmt.Print(color)
+				fmt.Fprint(stdout, color)
 				pct := 100 * (float64(bsf.textsize)/float64(asf.textsize) - 1)
-				fmt.Printf("%s %d -> %d  (%+0.2f%%)\n", cleanFuncName(name), asf.textsize, bsf.textsize, pct)
-				fmt.Print(ansiReset)
+				fmt.Fprintf(stdout, "%s %d -> %d  (%+0.2f%%)\n", cleanFuncName(name), asf.textsize, bsf.textsize, pct)
+				fmt.Fprint(stdout, ansiReset)
+				fmt.Fprint(stdout, diff)
 			}
 		}
 		for name, bsf := range bPkg.Funcs {
+			bTot += bsf.textsize
+			if fnRegexp != nil && !fnRegexp.MatchString(cleanFuncName(name)) {
+				continue
+			}
+			rep.Functions = append(rep.Functions, funcRecord{Package: pkg, Name: cleanFuncName(name), Change: "inserted", After: bsf.textsize})
 			if *flagFn != "stats" {
 				printHeader()
-				fmt.Println("inserted", cleanFuncName(name))
+				fmt.Fprintln(stdout, "inserted", cleanFuncName(name))
 			}
-			bTot += bsf.textsize
 		}
 		sizes.add(pkg+".s", int64(aTot), int64(bTot))
 		// TODO: option to print these
 		// printHeader()
 		// if aTot == bTot {
-		// 	fmt.Print(ansiFgBlue)
+		// 	fmt.Fprint(stdout, ansiFgBlue)
 		// } else if aTot < bTot {
-		// 	fmt.Print(ansiFgRed)
+		// 	fmt.Fprint(stdout, ansiFgRed)
 		// } else {
-		// 	fmt.Print(ansiFgGreen)
+		// 	fmt.Fprint(stdout, ansiFgGreen)
 		// }
 		// // TODO: instead, save totals and print at end
-		// fmt.Printf("%sTOTAL %d -> %d%s\n", ansiBold, aTot, bTot, ansiReset)
+		// fmt.Fprintf(stdout, "%sTOTAL %d -> %d%s\n", ansiBold, aTot, bTot, ansiReset)
 	}
 	sizes.flush("text size")
-	fmt.Println()
-	io.Copy(os.Stdout, sizesBuf)
+	rep.TextSizes = sizes.records
+	fmt.Fprintln(stdout)
+	io.Copy(stdout, sizesBuf)
 	for pkg := range aPkgs {
 		log.Printf("package %s was deleted", pkg)
 	}
@@ -189,9 +225,10 @@ func scanDashS(r io.Reader, sha []byte, c chan<- *pkgScanner) {
 				c <- pkgscan
 			}
 			pkgscan = &pkgScanner{
-				Name:  string(b[2:]),
-				Funcs: make(map[string]stextFunc),
-				Hash:  sha256.New(),
+				Name:     string(b[2:]),
+				Funcs:    make(map[string]stextFunc),
+				KeepBody: *flagFn == "diff",
+				Hash:     sha256.New(),
 			}
 			continue
 		}
@@ -207,11 +244,13 @@ func scanDashS(r io.Reader, sha []byte, c chan<- *pkgScanner) {
 }
 
 type pkgScanner struct {
-	Name  string
-	Funcs map[string]stextFunc
+	Name     string
+	Funcs    map[string]stextFunc
+	KeepBody bool // retain normalized function bodies, for diffing
 	// transient state
 	Hash  hash.Hash
 	stext string
+	body  []string
 }
 
 func (s *pkgScanner) ProcessLine(b []byte) {
@@ -220,8 +259,17 @@ func (s *pkgScanner) ProcessLine(b []byte) {
 		s.stext = string(b)
 		return
 	}
-	s.Hash.Write(b)
+	// Hash the normalized line, so that a function whose code
+	// has only moved (changing its pcs and positions) is unchanged.
+	line, ok := normalizeAsmLine(string(b))
+	if !ok {
+		return
+	}
+	s.Hash.Write([]byte(line))
 	s.Hash.Write([]byte{'\n'})
+	if s.KeepBody {
+		s.body = append(s.body, line)
+	}
 }
 
 func (s *pkgScanner) flush() {
@@ -230,15 +278,18 @@ func (s *pkgScanner) flush() {
 		s.Funcs[name] = stextFunc{
 			textsize: size,
 			bodyhash: s.Hash.Sum(nil),
+			body:     s.body,
 		}
 	}
 	s.Hash.Reset()
 	s.stext = ""
+	s.body = nil
 }
 
 type stextFunc struct {
-	textsize int    // length in instructions of the function
-	bodyhash []byte // hash of -S output for the function
+	textsize int      // length in instructions of the function
+	bodyhash []byte   // hash of normalized -S output for the function
+	body     []string // normalized -S output for the function, if KeepBody
 }
 
 func extractNameAndSize(stext string) (string, int) {
@@ -272,9 +323,9 @@ func extractNameAndSize(stext string) (string, int) {
 
 func streamDashS(platform string, c commit) (wait func(), r io.Reader) {
 	cmdgo := filepath.Join(c.dir, "bin", "go")
-	cmd := exec.Command(cmdgo, "build", "-gcflags=all=-S -dwarf=false", "std", "cmd")
-	goos, goarch := parsePlatform(platform)
-	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch)
+	args := append([]string{"build"}, c.flags.args("-S -dwarf=false")...)
+	cmd := exec.Command(cmdgo, append(args, "std", "cmd")...)
+	cmd.Env = c.environ(platform)
 	pipe, err := cmd.StderrPipe()
 	check(err)
 	err = cmd.Start()
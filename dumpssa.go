@@ -11,8 +11,8 @@ import (
 	"strings"
 )
 
-func dumpSSA(platform string, before, after commit, fnname string) {
-	fmt.Printf("dumping SSA for %v:\n", fnname)
+func dumpSSA(platform string, before, after commit, fnname string, rep *report) {
+	fmt.Fprintf(stdout, "dumping SSA for %v:\n", fnname)
 	// split fnname into pkg+fnname, if necessary
 	pkg, fnname := splitPkgFnname(fnname)
 	if pkg == "" {
@@ -30,19 +30,18 @@ func dumpSSA(platform string, before, after commit, fnname string) {
 
 	for _, c := range []commit{before, after} {
 		cmdgo := filepath.Join(c.dir, "bin", "go")
-		args := []string{"build"}
+		args := append([]string{"build"}, c.flags.args("")...)
 		if pkg != "" {
 			args = append(args, pkg)
 		} else {
 			args = append(args, "std", "cmd")
 		}
 		cmd := exec.Command(cmdgo, args...)
-		goos, goarch := parsePlatform(platform)
-		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "GOSSAFUNC="+fnname)
+		cmd.Env = append(c.environ(platform), "GOSSAFUNC="+fnname)
 		cmd.Dir = filepath.Join(c.dir, "src")
 		out, err := cmd.CombinedOutput()
 		if err != nil {
-			fmt.Printf("%v:\n%s\n", cmd, out)
+			fmt.Fprintf(os.Stderr, "%v:\n%s\n", cmd, out)
 			log.Fatal(err)
 		}
 
@@ -64,17 +63,18 @@ func dumpSSA(platform string, before, after commit, fnname string) {
 				src := path
 				prefix := ""
 				if platform != "" {
-					prefix = fmt.Sprintf("%s_%s_", goos, goarch)
+					prefix = strings.NewReplacer("/", "_", ",", "_", "=", "_").Replace(platformName(platform)) + "_"
 				}
 				dst := strings.TrimSuffix(path, "ssa.html") + prefix + filename + ".html"
 				err = os.Rename(src, dst)
 				check(err)
-				fmt.Println(dst)
+				fmt.Fprintln(stdout, dst)
+				rep.SSAFiles = append(rep.SSAFiles, dst)
 			}
 		}
 		check(scan.Err())
 	}
-	fmt.Println()
+	fmt.Fprintln(stdout)
 }
 
 func splitPkgFnname(in string) (pkg, fnname string) {
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A clRevision is a patchset of a Gerrit CL.
+type clRevision struct {
+	sha      string
+	parent   string // first parent; empty if none
+	patchset int
+	fetchURL string
+	fetchRef string
+}
+
+// A clInfo is what we know about a Gerrit CL.
+type clInfo struct {
+	number    int
+	current   string                // sha of the current revision
+	revisions map[string]clRevision // keyed by sha
+}
+
+// parseCLFlag parses the -cl flag, which is a CL number,
+// optionally followed by a slash and a patchset number.
+// patchset is 0 if absent.
+func parseCLFlag(s string) (cl, patchset int, err error) {
+	num, ps, hasPS := strings.Cut(s, "/")
+	cl, err = strconv.Atoi(num)
+	if err != nil || cl <= 0 {
+		return 0, 0, fmt.Errorf("bad -cl %q: want CL number or CL/patchset", s)
+	}
+	if hasPS {
+		patchset, err = strconv.Atoi(ps)
+		if err != nil || patchset <= 0 {
+			return 0, 0, fmt.Errorf("bad -cl %q: bad patchset number", s)
+		}
+	}
+	return cl, patchset, nil
+}
+
+// parsePatchsets parses the -patchsets flag, a comma-separated pair of patchset numbers.
+func parsePatchsets(s string) (a, b int, err error) {
+	x, y, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad -patchsets %q: want two patchsets, like 3,5", s)
+	}
+	a, errA := strconv.Atoi(strings.TrimSpace(x))
+	b, errB := strconv.Atoi(strings.TrimSpace(y))
+	if errA != nil || errB != nil || a <= 0 || b <= 0 {
+		return 0, 0, fmt.Errorf("bad -patchsets %q: want two patchsets, like 3,5", s)
+	}
+	return a, b, nil
+}
+
+// Gerrit is configured with the -gerrit flag and environment variables:
+//
+//	COMPILECMP_GERRIT: the Gerrit base URL, if -gerrit is not set (default https://go-review.googlesource.com)
+//	COMPILECMP_GERRIT_USER, COMPILECMP_GERRIT_PASSWORD: HTTP credentials, for Gerrits that require them
+//
+// CLs whose patchsets have already been fetched into refs/changes/* are resolved
+// locally, without using the network.
+
+const defaultGerrit = "https://go-review.googlesource.com"
+
+// A gerritClient queries a Gerrit server's REST API.
+type gerritClient struct {
+	baseURL  string
+	user     string // if set, use HTTP basic authentication
+	password string
+	client   *http.Client
+}
+
+// newGerritClient returns a client for the Gerrit at baseURL,
+// or the configured Gerrit if baseURL is empty.
+func newGerritClient(baseURL string) *gerritClient {
+	if baseURL == "" {
+		baseURL = os.Getenv("COMPILECMP_GERRIT")
+	}
+	if baseURL == "" {
+		baseURL = defaultGerrit
+	}
+	return &gerritClient{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		user:     os.Getenv("COMPILECMP_GERRIT_USER"),
+		password: os.Getenv("COMPILECMP_GERRIT_PASSWORD"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// queryCL fetches information about all revisions of cl from Gerrit.
+func (g *gerritClient) queryCL(cl int) (*clInfo, error) {
+	// Authenticated requests go to /a/.
+	prefix := ""
+	if g.user != "" {
+		prefix = "/a"
+	}
+	url := fmt.Sprintf("%s%s/changes/%d/?o=CURRENT_REVISION&o=ALL_REVISIONS&o=ALL_COMMITS", g.baseURL, prefix, cl)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.user != "" {
+		req.SetBasicAuth(g.user, g.password)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gerrit: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseCLInfo(cl, body)
+}
+
+// localCL returns information about the revisions of cl that have been
+// fetched into refs/changes/*, or nil if there are none.
+// The current revision is the highest numbered patchset present.
+func localCL(cl int) (*clInfo, error) {
+	// Gerrit change refs are refs/changes/NN/CL/PATCHSET,
+	// where NN is the last two digits of the CL number.
+	prefix := fmt.Sprintf("refs/changes/%02d/%d/", cl%100, cl)
+	out, err := git("for-each-ref", "--format=%(objectname) %(refname)", prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s: %v", prefix, err)
+	}
+	return parseLocalCL(cl, string(out), prefix, func(sha string) string {
+		parent, err := git("rev-parse", "--verify", "--quiet", sha+"^")
+		if err != nil {
+			return ""
+		}
+		return string(parent)
+	})
+}
+
+// parseLocalCL parses the output of git for-each-ref for the change refs of cl,
+// using parentOf to look up each revision's first parent.
+func parseLocalCL(cl int, refs, prefix string, parentOf func(sha string) string) (*clInfo, error) {
+	info := &clInfo{number: cl, revisions: make(map[string]clRevision)}
+	current := 0
+	for _, line := range strings.Split(refs, "\n") {
+		sha, ref, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		ps, err := strconv.Atoi(strings.TrimPrefix(ref, prefix))
+		if err != nil {
+			continue // refs/changes/NN/CL/meta, for example
+		}
+		info.revisions[sha] = clRevision{sha: sha, parent: parentOf(sha), patchset: ps}
+		if ps > current {
+			current = ps
+			info.current = sha
+		}
+	}
+	if len(info.revisions) == 0 {
+		return nil, nil
+	}
+	return info, nil
+}
+
+// parseCLInfo parses a Gerrit ChangeInfo JSON response.
+func parseCLInfo(cl int, body []byte) (*clInfo, error) {
+	// Work around https://code.google.com/p/gerrit/issues/detail?id=3540
+	body = bytes.TrimPrefix(body, []byte(")]}'"))
+
+	var parse struct {
+		CurrentRevision string `json:"current_revision"`
+		Revisions       map[string]struct {
+			Number int `json:"_number"`
+			Fetch  struct {
+				HTTP struct {
+					URL string
+					Ref string
+				}
+			}
+			Commit struct {
+				Parents []struct {
+					Commit string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &parse); err != nil {
+		return nil, err
+	}
+	info := &clInfo{number: cl, current: parse.CurrentRevision, revisions: make(map[string]clRevision)}
+	for sha, r := range parse.Revisions {
+		rev := clRevision{
+			sha:      sha,
+			patchset: r.Number,
+			fetchURL: r.Fetch.HTTP.URL,
+			fetchRef: r.Fetch.HTTP.Ref,
+		}
+		if len(r.Commit.Parents) > 0 {
+			rev.parent = r.Commit.Parents[0].Commit
+		}
+		info.revisions[sha] = rev
+	}
+	if _, ok := info.revisions[info.current]; !ok {
+		return nil, errors.New("gerrit response has no current revision")
+	}
+	return info, nil
+}
+
+// patchset returns the revision for patchset n, or the current revision if n is 0.
+func (c *clInfo) patchset(n int) (clRevision, error) {
+	if n == 0 {
+		return c.revisions[c.current], nil
+	}
+	for _, rev := range c.revisions {
+		if rev.patchset == n {
+			return rev, nil
+		}
+	}
+	return clRevision{}, fmt.Errorf("CL %d has no patchset %d", c.number, n)
+}
+
+// fetch fetches rev into the local repository, if it came from Gerrit.
+func (rev clRevision) fetch() error {
+	if rev.fetchRef == "" {
+		return nil
+	}
+	_, err := git("fetch", rev.fetchURL, rev.fetchRef)
+	return err
+}
+
+// hasPatchsets reports whether c has all of patchsets.
+// The current patchset (0) is known only from Gerrit itself.
+func (c *clInfo) hasPatchsets(patchsets ...int) bool {
+	for _, n := range patchsets {
+		if n == 0 {
+			return false
+		}
+		if _, err := c.patchset(n); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveCL returns information about cl that includes patchsets (0 for the current one).
+// It uses local change refs if they suffice, and otherwise asks g,
+// falling back to local change refs if g is unreachable.
+func resolveCL(g *gerritClient, cl int, patchsets ...int) (*clInfo, error) {
+	local, err := localCL(cl)
+	if err != nil {
+		return nil, err
+	}
+	if local != nil && local.hasPatchsets(patchsets...) {
+		return local, nil
+	}
+	info, err := g.queryCL(cl)
+	if err != nil {
+		if local != nil {
+			log.Printf("could not query %s (%v); using local refs for CL %d", g.baseURL, err, cl)
+			return local, nil
+		}
+		return nil, fmt.Errorf("failed to get CL %d information: %v", cl, err)
+	}
+	return info, nil
+}
+
+// clRefs returns the commits to compare for -cl and -patchsets, after fetching them.
+// With just a CL (and optionally a patchset), they are the patchset's parent and the patchset.
+// With -patchsets a,b, they are patchsets a and b.
+func clRefs(g *gerritClient, clFlag, patchsetsFlag string) (before, after string, err error) {
+	cl, ps, err := parseCLFlag(clFlag)
+	if err != nil {
+		return "", "", err
+	}
+	var a, b int
+	if patchsetsFlag != "" {
+		if ps != 0 {
+			return "", "", errors.New("-patchsets is incompatible with -cl CL/patchset")
+		}
+		a, b, err = parsePatchsets(patchsetsFlag)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if patchsetsFlag == "" {
+		info, err := resolveCL(g, cl, ps)
+		if err != nil {
+			return "", "", err
+		}
+		rev, err := info.patchset(ps)
+		if err != nil {
+			return "", "", err
+		}
+		if rev.parent == "" {
+			return "", "", errors.New("CL does not have parent")
+		}
+		if err := rev.fetch(); err != nil {
+			return "", "", err
+		}
+		return rev.parent, rev.sha, nil
+	}
+	info, err := resolveCL(g, cl, a, b)
+	if err != nil {
+		return "", "", err
+	}
+	revA, err := info.patchset(a)
+	if err != nil {
+		return "", "", err
+	}
+	revB, err := info.patchset(b)
+	if err != nil {
+		return "", "", err
+	}
+	for _, rev := range []clRevision{revA, revB} {
+		if err := rev.fetch(); err != nil {
+			return "", "", err
+		}
+	}
+	return revA.sha, revB.sha, nil
+}
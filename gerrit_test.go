@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseCLFlag(t *testing.T) {
+	for _, tt := range []struct {
+		in           string
+		cl, patchset int
+		ok           bool
+	}{
+		{"12345", 12345, 0, true},
+		{"12345/3", 12345, 3, true},
+		{"12345/", 0, 0, false},
+		{"abc", 0, 0, false},
+		{"12345/0", 0, 0, false},
+	} {
+		cl, ps, err := parseCLFlag(tt.in)
+		if (err == nil) != tt.ok || cl != tt.cl || ps != tt.patchset {
+			t.Errorf("parseCLFlag(%q) = %d, %d, %v", tt.in, cl, ps, err)
+		}
+	}
+}
+
+func TestParsePatchsets(t *testing.T) {
+	a, b, err := parsePatchsets("3,5")
+	if err != nil || a != 3 || b != 5 {
+		t.Errorf("parsePatchsets(3,5) = %d, %d, %v", a, b, err)
+	}
+	for _, in := range []string{"3", "3,x", "0,1"} {
+		if _, _, err := parsePatchsets(in); err == nil {
+			t.Errorf("parsePatchsets(%q) succeeded, want error", in)
+		}
+	}
+}
+
+const testChangeInfo = `)]}'
+{
+  "current_revision": "cccc",
+  "revisions": {
+    "aaaa": {
+      "_number": 1,
+      "fetch": {"http": {"url": "https://go.googlesource.com/go", "ref": "refs/changes/45/12345/1"}},
+      "commit": {"parents": [{"commit": "pppp"}]}
+    },
+    "cccc": {
+      "_number": 2,
+      "fetch": {"http": {"url": "https://go.googlesource.com/go", "ref": "refs/changes/45/12345/2"}},
+      "commit": {"parents": [{"commit": "qqqq"}]}
+    }
+  }
+}`
+
+func TestParseCLInfo(t *testing.T) {
+	info, err := parseCLInfo(12345, []byte(testChangeInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := info.patchset(0)
+	if err != nil || cur.sha != "cccc" || cur.parent != "qqqq" || cur.patchset != 2 {
+		t.Errorf("current revision = %+v, %v", cur, err)
+	}
+	ps1, err := info.patchset(1)
+	if err != nil || ps1.sha != "aaaa" || ps1.fetchRef != "refs/changes/45/12345/1" {
+		t.Errorf("patchset 1 = %+v, %v", ps1, err)
+	}
+	if _, err := info.patchset(3); err == nil {
+		t.Errorf("patchset 3 found, want error")
+	}
+}
+
+func TestGerritClient(t *testing.T) {
+	var gotPath, gotUser, gotPassword string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUser, gotPassword, _ = r.BasicAuth()
+		if !strings.HasSuffix(r.URL.Path, "/changes/12345/") {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, testChangeInfo)
+	}))
+	defer srv.Close()
+
+	t.Setenv("COMPILECMP_GERRIT_USER", "")
+	g := newGerritClient(srv.URL + "/")
+	info, err := g.queryCL(12345)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/changes/12345/" || info.current != "cccc" {
+		t.Errorf("anonymous query: path %q, current %q", gotPath, info.current)
+	}
+	if _, err := g.queryCL(99999); err == nil {
+		t.Errorf("query for missing CL succeeded")
+	}
+
+	t.Setenv("COMPILECMP_GERRIT", srv.URL)
+	t.Setenv("COMPILECMP_GERRIT_USER", "gopher")
+	t.Setenv("COMPILECMP_GERRIT_PASSWORD", "secret")
+	g = newGerritClient("")
+	if _, err := g.queryCL(12345); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/a/changes/12345/" || gotUser != "gopher" || gotPassword != "secret" {
+		t.Errorf("authenticated query: path %q, user %q, password %q", gotPath, gotUser, gotPassword)
+	}
+}
+
+func TestParseLocalCL(t *testing.T) {
+	const prefix = "refs/changes/45/12345/"
+	refs := "aaaa refs/changes/45/12345/1\nbbbb refs/changes/45/12345/3\ncccc refs/changes/45/12345/meta\n"
+	info, err := parseLocalCL(12345, refs, prefix, func(sha string) string { return sha + "^" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := info.patchset(0)
+	if err != nil || cur.sha != "bbbb" || cur.parent != "bbbb^" {
+		t.Errorf("current revision = %+v, %v", cur, err)
+	}
+	if !info.hasPatchsets(1, 3) || info.hasPatchsets(2) || info.hasPatchsets(0) {
+		t.Errorf("hasPatchsets wrong for patchsets 1 and 3")
+	}
+	if rev, _ := info.patchset(1); rev.fetchRef != "" {
+		t.Errorf("local revision has fetch ref %q", rev.fetchRef)
+	}
+	if info, err := parseLocalCL(12345, "", prefix, nil); info != nil || err != nil {
+		t.Errorf("parseLocalCL with no refs = %v, %v; want nil, nil", info, err)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// writeHTMLFile writes reps as a self-contained HTML report to the file at path.
+func writeHTMLFile(path string, reps []*report) {
+	f, err := os.Create(path)
+	check(err)
+	check(writeHTML(f, reps))
+	check(f.Close())
+	fmt.Fprintf(stdout, "wrote HTML report to %s\n", path)
+}
+
+// An htmlReport is a report arranged for the HTML template.
+type htmlReport struct {
+	*report
+	Packages []htmlPackage
+}
+
+// An htmlPackage is the changed functions in a package.
+type htmlPackage struct {
+	Name      string
+	Functions []funcRecord
+	Before    int // total text size of the changed functions
+	After     int
+}
+
+func newHTMLReport(rep *report) htmlReport {
+	h := htmlReport{report: rep}
+	byPkg := make(map[string]*htmlPackage)
+	for _, f := range rep.Functions {
+		p, ok := byPkg[f.Package]
+		if !ok {
+			p = &htmlPackage{Name: f.Package}
+			byPkg[f.Package] = p
+		}
+		p.Functions = append(p.Functions, f)
+		p.Before += f.Before
+		p.After += f.After
+	}
+	for _, p := range byPkg {
+		sort.Slice(p.Functions, func(i, j int) bool { return p.Functions[i].Name < p.Functions[j].Name })
+		h.Packages = append(h.Packages, *p)
+	}
+	sort.Slice(h.Packages, func(i, j int) bool { return h.Packages[i].Name < h.Packages[j].Name })
+	return h
+}
+
+var htmlFuncs = template.FuncMap{
+	"shortsha": shortsha,
+	"delta": func(before, after int64) string {
+		return fmt.Sprintf("%+d", after-before)
+	},
+	"pct": func(before, after int64) string {
+		switch {
+		case before == 0:
+			return "(added)"
+		case after == 0:
+			return "(removed)"
+		}
+		return fmt.Sprintf("%+0.3f%%", 100*float64(after)/float64(before)-100)
+	},
+	"size": func(size int64) string {
+		if size == 0 {
+			return "-"
+		}
+		return fmt.Sprint(size)
+	},
+	"int64": func(n int) int64 { return int64(n) },
+	"changed": func(records []sizeRecord) []sizeRecord {
+		return filterSizes(records, true)
+	},
+	"unchanged": func(records []sizeRecord) []sizeRecord {
+		return filterSizes(records, false)
+	},
+	"summary": func(median float64, ci *interval, unit string) string {
+		return formatSummary(median, ci, unit)
+	},
+	"benchDelta": func(row benchRow) string {
+		if !row.Significant {
+			return "~"
+		}
+		return fmt.Sprintf("%+.2f%%", row.Delta)
+	},
+	"fileURL": func(path string) template.URL {
+		u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+		return template.URL(u.String())
+	},
+	"diffClass": func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			return "hdr"
+		case strings.HasPrefix(line, "@@"):
+			return "hunk"
+		case strings.HasPrefix(line, "+"):
+			return "add"
+		case strings.HasPrefix(line, "-"):
+			return "del"
+		}
+		return ""
+	},
+	"lines": func(s string) []string {
+		return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	},
+}
+
+// filterSizes returns the records whose size changed, or those whose size did not.
+func filterSizes(records []sizeRecord, changed bool) []sizeRecord {
+	var list []sizeRecord
+	for _, r := range records {
+		if (r.Before != r.After) == changed {
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// writeHTML writes reps as a self-contained HTML report.
+func writeHTML(w io.Writer, reps []*report) error {
+	t, err := template.New("report").Funcs(htmlFuncs).Parse(htmlTemplate)
+	if err != nil {
+		return err
+	}
+	var hs []htmlReport
+	for _, rep := range reps {
+		hs = append(hs, newHTMLReport(rep))
+	}
+	return t.Execute(w, hs)
+}
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>compilecmp</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.2em; border-bottom: 1px solid #ccc; }
+h3 { font-size: 1em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { padding: 2px 8px; text-align: right; font-family: monospace; }
+th:first-child, td:first-child { text-align: left; }
+th { cursor: pointer; border-bottom: 1px solid #888; user-select: none; }
+tr:nth-child(even) { background: #f4f4f4; }
+td.grown { color: #b00; }
+td.shrunk { color: #070; }
+summary { cursor: pointer; font-family: monospace; }
+pre { background: #f8f8f8; padding: 4px; overflow-x: auto; }
+pre .add { color: #070; }
+pre .del { color: #b00; }
+pre .hunk { color: #07a; }
+pre .hdr { font-weight: bold; }
+.commit { font-family: monospace; }
+</style>
+<script>
+// Sort a table by a column when its header is clicked.
+// Numeric columns sort numerically; others sort as text.
+function sortTable(th) {
+	const table = th.closest("table");
+	const tbody = table.tBodies[0];
+	const col = Array.from(th.parentNode.children).indexOf(th);
+	const asc = th.dataset.order !== "asc";
+	th.dataset.order = asc ? "asc" : "desc";
+	const key = td => {
+		const s = td.textContent.trim().replace(/[%+,]|^\(|\)$/g, "");
+		const n = parseFloat(s);
+		return isNaN(n) ? s : n;
+	};
+	const rows = Array.from(tbody.rows);
+	rows.sort((a, b) => {
+		const x = key(a.cells[col]), y = key(b.cells[col]);
+		let c;
+		if (typeof x === "number" && typeof y === "number") {
+			c = x - y;
+		} else {
+			c = String(x).localeCompare(String(y));
+		}
+		return asc ? c : -c;
+	});
+	rows.forEach(r => tbody.appendChild(r));
+}
+document.addEventListener("click", e => {
+	if (e.target.tagName === "TH") {
+		sortTable(e.target);
+	}
+});
+</script>
+</head>
+<body>
+<h1>compilecmp</h1>
+{{range .}}
+<h2>{{.Before.Ref}} &rarr; {{.After.Ref}} ({{.Platform}})</h2>
+<p class="commit">{{shortsha .Before.SHA}}: {{.Before.Subject}}<br>{{shortsha .After.SHA}}: {{.After.Subject}}</p>
+{{if .BeforeFlags}}<p>before flags: <code>{{.BeforeFlags}}</code></p>{{end}}
+{{if .AfterFlags}}<p>after flags: <code>{{.AfterFlags}}</code></p>{{end}}
+{{if .BeforeLdflags}}<p>before ldflags: <code>{{.BeforeLdflags}}</code></p>{{end}}
+{{if .AfterLdflags}}<p>after ldflags: <code>{{.AfterLdflags}}</code></p>{{end}}
+{{if .BeforeAsmflags}}<p>before asmflags: <code>{{.BeforeAsmflags}}</code></p>{{end}}
+{{if .AfterAsmflags}}<p>after asmflags: <code>{{.AfterAsmflags}}</code></p>{{end}}
+{{if .BeforeEnv}}<p>before env: <code>{{.BeforeEnv}}</code></p>{{end}}
+{{if .AfterEnv}}<p>after env: <code>{{.AfterEnv}}</code></p>{{end}}
+
+{{if .Bench}}
+<h3>Benchmarks</h3>
+<table>
+<thead><tr><th>name</th><th>unit</th><th>before</th><th>after</th><th>&Delta;</th><th>p</th><th>n</th></tr></thead>
+<tbody>
+{{range .Bench}}<tr><td>{{.Name}}</td><td>{{.Unit}}</td><td>{{summary .BeforeMedian .BeforeCI .Unit}}</td><td>{{summary .AfterMedian .AfterCI .Unit}}</td><td>{{benchDelta .}}</td><td>{{printf "%.3f" .P}}</td><td>{{len .Before}}+{{len .After}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+
+<h3>Binaries</h3>
+{{template "sizes" .Binaries}}
+{{if .ContentChanged}}<p>same size, different contents: {{range $i, $b := .ContentChanged}}{{if $i}}, {{end}}{{$b}}{{end}}</p>{{end}}
+{{range .Sections}}
+<details><summary>{{.Binary}} sections</summary>
+{{template "sizes" .Sections}}
+</details>
+{{end}}
+{{range .Symbols}}
+<details><summary>{{.Binary}} symbols</summary>
+<table>
+<thead><tr><th>symbols</th><th>before</th><th>after</th><th>&Delta;</th></tr></thead>
+<tbody>
+<tr><td>text</td><td>{{.TextBefore}}</td><td>{{.TextAfter}}</td><td>{{delta .TextBefore .TextAfter}}</td></tr>
+<tr><td>data</td><td>{{.DataBefore}}</td><td>{{.DataAfter}}</td><td>{{delta .DataBefore .DataAfter}}</td></tr>
+</tbody>
+</table>
+<table>
+<thead><tr><th>symbol</th><th>change</th><th>before</th><th>after</th><th>&Delta;</th></tr></thead>
+<tbody>
+{{range .Added}}<tr><td>{{.Name}}</td><td>added</td><td>{{size .Before}}</td><td>{{size .After}}</td><td>{{delta .Before .After}}</td></tr>
+{{end}}{{range .Removed}}<tr><td>{{.Name}}</td><td>removed</td><td>{{size .Before}}</td><td>{{size .After}}</td><td>{{delta .Before .After}}</td></tr>
+{{end}}{{range .Grown}}<tr><td>{{.Name}}</td><td>grown</td><td>{{size .Before}}</td><td>{{size .After}}</td><td>{{delta .Before .After}}</td></tr>
+{{end}}{{range .Shrunk}}<tr><td>{{.Name}}</td><td>shrunk</td><td>{{size .Before}}</td><td>{{size .After}}</td><td>{{delta .Before .After}}</td></tr>
+{{end}}</tbody>
+</table>
+</details>
+{{end}}
+
+{{if .Objects}}
+<h3>Object files</h3>
+{{template "sizes" .Objects}}
+{{if .ObjectKinds}}{{template "sizes" .ObjectKinds}}{{end}}
+{{if .ObjectMembers}}<details><summary>archive members</summary>
+{{template "sizes" .ObjectMembers}}
+</details>{{end}}
+{{end}}
+
+{{if .TextSizes}}
+<h3>Text size by package</h3>
+{{template "sizes" .TextSizes}}
+{{end}}
+
+{{if .Packages}}
+<h3>Changed functions</h3>
+{{range .Packages}}
+<details><summary>{{.Name}} ({{len .Functions}} functions, {{delta (int64 .Before) (int64 .After)}} bytes)</summary>
+<table>
+<thead><tr><th>function</th><th>change</th><th>before</th><th>after</th><th>&Delta;</th></tr></thead>
+<tbody>
+{{range .Functions}}<tr><td>{{.Name}}</td><td>{{.Change}}</td><td>{{size (int64 .Before)}}</td><td>{{size (int64 .After)}}</td><td class="{{.Change}}">{{delta (int64 .Before) (int64 .After)}}</td></tr>
+{{end}}</tbody>
+</table>
+{{range .Functions}}{{if .Diff}}<details><summary>{{.Name}} assembly diff</summary>
+<pre>{{range lines .Diff}}<span class="{{diffClass .}}">{{.}}</span>
+{{end}}</pre>
+</details>
+{{end}}{{end}}
+</details>
+{{end}}
+{{end}}
+
+{{if .SSAFiles}}
+<h3>SSA dumps</h3>
+<ul>
+{{range .SSAFiles}}<li><a href="{{fileURL .}}">{{.}}</a></li>
+{{end}}</ul>
+{{end}}
+{{end}}
+</body>
+</html>
+
+{{/* Unchanged rows are collapsed, so that the changes stand out, as in the text report. */}}
+{{define "sizes"}}{{with changed .}}{{template "sizetable" .}}{{else}}<p>no changes</p>{{end}}
+{{with unchanged .}}<details><summary>{{len .}} unchanged</summary>
+{{template "sizetable" .}}
+</details>{{end}}{{end}}
+
+{{define "sizetable"}}<table>
+<thead><tr><th>name</th><th>before</th><th>after</th><th>&Delta;</th><th>%</th></tr></thead>
+<tbody>
+{{range .}}<tr><td>{{.Name}}</td><td>{{size .Before}}</td><td>{{size .After}}</td><td>{{delta .Before .After}}</td><td>{{pct .Before .After}}</td></tr>
+{{end}}</tbody>
+</table>{{end}}
+`
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTML(t *testing.T) {
+	rep := &report{
+		Before:   reportCommit{Ref: "master", SHA: "0123456789abcdef", Subject: "base"},
+		After:    reportCommit{Ref: "HEAD", SHA: "fedcba9876543210", Subject: "cmd/compile: use <b> & friends"},
+		Platform: "linux/amd64",
+		Binaries: []sizeRecord{{"compile", 1000, 1100}, {"link", 500, 500}},
+		Functions: []funcRecord{
+			{Package: "strconv", Name: "Itoa", Change: "grown", Before: 10, After: 12,
+				Diff: "--- before/Itoa\n+++ after/Itoa\n@@ -1 +1 @@\n-\tMOVQ\tAX, BX\n+\tMOVL\tAX, BX\n"},
+		},
+		Bench:    []benchRow{{Name: "Template", Unit: "ns/op", Before: []float64{100}, After: []float64{110}, BeforeMedian: 100, AfterMedian: 110, Delta: 10, P: 0.01, Significant: true}},
+		SSAFiles: []string{"/tmp/go/src/strconv/Itoa.html"},
+	}
+	var buf bytes.Buffer
+	if err := writeHTML(&buf, []*report{rep}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	// html/template escapes + as &#43;.
+	for _, want := range []string{
+		"use &lt;b&gt; &amp; friends",
+		"<td>compile</td><td>1000</td><td>1100</td><td>&#43;100</td><td>&#43;10.000%</td>",
+		"<summary>strconv (1 functions, &#43;2 bytes)</summary>",
+		`<span class="add">&#43;	MOVL	AX, BX</span>`,
+		"<td>&#43;10.00%</td>",
+		`<a href="file:///tmp/go/src/strconv/Itoa.html">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("HTML report missing %q", want)
+		}
+	}
+	// Unchanged rows come after the changed ones, collapsed.
+	if i, j := strings.Index(out, "<td>compile</td>"), strings.Index(out, "<summary>1 unchanged</summary>"); i < 0 || j < i || !strings.Contains(out[j:], "<td>link</td>") {
+		t.Errorf("HTML report does not collapse unchanged binary link")
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("HTML report contains ANSI escapes")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// File locking is only implemented on Unix systems.
+// Elsewhere, it is not safe to run multiple compilecmps concurrently.
+
+func flock(f *os.File, exclusive, block bool) (bool, error) {
+	return true, nil
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
@@ -2,68 +2,102 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
-	"net/http"
 	"os"
 	"os/exec"
-	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"text/tabwriter"
 	"time"
+	"unicode"
 )
 
 const debug = false // print commands as they are run
 
 var (
-	flagRun     = flag.String("run", "", "run benchmarks matching regex")
-	flagAll     = flag.Bool("all", false, "run all benchmarks, not just short ones")
-	flagCPU     = flag.Bool("cpu", false, "run only CPU tests, not alloc tests")
-	flagObj     = flag.Bool("obj", false, "report object file sizes")
-	flagPkg     = flag.String("pkg", "", "benchmark compilation of `pkg`")
-	flagCount   = flag.Int("n", 0, "iterations")
-	flagEach    = flag.Bool("each", false, "run for every commit between before and after")
-	flagCL      = flag.Int("cl", 0, "run benchmark on CL number")
-	flagFn      = flag.String("fn", "", "find changed functions: all, changed, smaller, bigger, stats, or help")
-	flagDumpSSA = flag.String("dumpssa", "", "dump SSA html for named functions (use like GOSSAFUNC)")
-	flagAllBash = flag.Bool("allbash", false, "run all.bash for each commit")
+	flagRun       = flag.String("run", "", "run benchmarks matching regex")
+	flagAll       = flag.Bool("all", false, "run all benchmarks, not just short ones")
+	flagCPU       = flag.Bool("cpu", false, "report only CPU metrics, not memory use")
+	flagObj       = flag.Bool("obj", false, "report object file sizes")
+	flagSyms      = flag.Int("syms", 0, "report the `n` largest symbol changes in each changed binary")
+	flagPkg       = flag.String("pkg", "", "benchmark compilation of `pkg`")
+	flagCount     = flag.Int("n", 0, "iterations")
+	flagEach      = flag.Bool("each", false, "run for every commit between before and after")
+	flagCL        = flag.String("cl", "", "run benchmark on CL number, optionally followed by /patchset")
+	flagPR        = flag.Int("pr", 0, "run benchmark on pull request number, compared to its merge base with -prbase")
+	flagRemote    = flag.String("remote", "origin", "git remote to fetch -pr pull requests from")
+	flagPRRef     = flag.String("prref", defaultPRRef, "ref `pattern` for -pr pull requests; the path element N is the pull request number")
+	flagPRBase    = flag.String("prbase", "master", "target `branch` of -pr pull requests on -remote")
+	flagDirty     = flag.Bool("dirty", false, "compare against a snapshot of the uncommitted changes in the working tree")
+	flagPatch     = flag.String("patch", "", "compare against the after ref with patch `file` applied")
+	flagGerrit    = flag.String("gerrit", "", "Gerrit base `URL` for -cl (default $COMPILECMP_GERRIT or "+defaultGerrit+")")
+	flagPatchsets = flag.String("patchsets", "", "with -cl, compare two patchsets `a,b` of the CL instead of a patchset and its parent")
+	flagFn        = flag.String("fn", "", "find changed functions: all, changed, smaller, bigger, stats, diff, or help")
+	flagFnRe      = flag.String("fnre", "", "with -fn, report only functions matching `regexp`")
+	flagDumpSSA   = flag.String("dumpssa", "", "dump SSA html for named functions (use like GOSSAFUNC)")
+	flagAllBash   = flag.Bool("allbash", false, "run all.bash for each commit")
+	flagJobs      = flag.Int("j", 2, "build up to `n` toolchains, or prepare up to n platforms, at once")
+	flagJSON      = flag.Bool("json", false, "print a JSON report for each comparison instead of text")
+	flagBisect    = flag.String("bisect", "", "find the first commit between before and after at which `cond` holds")
+	flagHTML      = flag.String("html", "", "write a self-contained HTML report to `file`")
+	flagCSV       = flag.String("csv", "", "with -each, write the trend summary as CSV to `file`")
+	flagFresh     = flag.Bool("fresh", false, "ignore results stored by previous runs")
+	flagCache     = flag.String("cache", "", "cache `dir` for GOROOTs (default $COMPILECMP_CACHE or ~/.compilecmp)")
 
 	flagFlags       = flag.String("flags", "", "compiler flags for both before and after")
 	flagBeforeFlags = flag.String("beforeflags", "", "compiler flags for before")
 	flagAfterFlags  = flag.String("afterflags", "", "compiler flags for after")
-	flagPlatforms   = flag.String("platforms", "", "comma-separated list of platforms to compile for; all=all platforms, arch=one platform per arch")
+	flagLdflags     = flag.String("ldflags", "", "linker flags for both before and after")
+	flagBeforeLd    = flag.String("beforeldflags", "", "linker flags for before")
+	flagAfterLd     = flag.String("afterldflags", "", "linker flags for after")
+	flagAsmflags    = flag.String("asmflags", "", "assembler flags for both before and after")
+	flagBeforeAsm   = flag.String("beforeasmflags", "", "assembler flags for before")
+	flagAfterAsm    = flag.String("afterasmflags", "", "assembler flags for after")
+	flagEnv         = flag.String("env", "", "space-separated `KEY=value` environment settings for both before and after; quote values with spaces")
+	flagBeforeEnv   = flag.String("beforeenv", "", "environment settings for before")
+	flagAfterEnv    = flag.String("afterenv", "", "environment settings for after")
+	flagPlatforms   = flag.String("platforms", "", "comma-separated list of platforms to compile for; all=all platforms, arch=one platform per arch, variants=each arch at each micro-architecture level")
 )
 
 var cwd string
 
+// fnRegexp is the compiled -fnre flag, or nil.
+var fnRegexp *regexp.Regexp
+
 func main() {
-	flag.Parse()
+	// The cache subcommand must be the first argument, so that a ref named cache
+	// can still be compared (compilecmp -- cache). Flags may follow it.
+	isCache := len(os.Args) > 1 && os.Args[1] == "cache"
+	var cacheArgs []string
+	if isCache {
+		cacheArgs = parseCacheArgs(flag.CommandLine, os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 	log.SetFlags(0)
-
-	// Fail fast if benchstat is missing; otherwise we'd run the full benchmark
-	// suite (potentially hours) and only discover the problem at the very end.
-	if *flagCount > 0 {
-		if _, err := exec.LookPath("benchstat"); err != nil {
-			log.Fatalf("benchstat not found in PATH; install with 'go install golang.org/x/perf/cmd/benchstat@latest'")
-		}
+	if isCache && *flagJSON {
+		log.Fatal("compilecmp cache does not support -json")
 	}
 
-	cleanCache()
+	if *flagJSON {
+		stdout = io.Discard
+		progress = os.Stderr
+	}
 
 	// Make a temp dir to use for the GOCACHE.
 	// See golang.org/issue/29561.
 	dir, err := os.MkdirTemp("", "compilecmp-gocache-")
 	check(err)
 	if debug {
-		fmt.Printf("GOCACHE=%s\n", dir)
+		fmt.Fprintf(stdout, "GOCACHE=%s\n", dir)
 	}
 	defer os.RemoveAll(dir)
 	os.Setenv("GOCACHE", dir)
@@ -72,43 +106,139 @@ func main() {
 	if err != nil {
 		log.Fatalf("could not get current working dir: %v", err)
 	}
+	if isCache {
+		cacheCommand(cacheArgs)
+		return
+	}
 	beforeRef := "master"
 	afterRef := "HEAD"
-	if *flagCL != 0 {
+	if *flagPatchsets != "" && *flagCL == "" {
+		log.Fatal("-patchsets requires -cl")
+	}
+	if *flagCL != "" {
 		if flag.NArg() > 0 {
 			log.Fatal("-cl NNN is incompatible with ref arguments")
 		}
 		if *flagEach {
 			log.Fatal("-cl NNN is incompatible with -each (a CL is a single commit)")
 		}
-		clHead, parent, err := clHeadAndParent(*flagCL)
+		beforeRef, afterRef, err = clRefs(newGerritClient(*flagGerrit), *flagCL, *flagPatchsets)
 		if err != nil {
-			log.Fatalf("failed to get CL %d information: %v", *flagCL, err)
+			log.Fatal(err)
+		}
+	}
+	if *flagPR != 0 {
+		if *flagCL != "" {
+			log.Fatal("-pr is incompatible with -cl")
 		}
-		if parent == "" {
-			log.Fatal("CL does not have parent")
+		if flag.NArg() > 0 {
+			log.Fatal("-pr NNN is incompatible with ref arguments")
+		}
+		beforeRef, afterRef, err = prRefs(*flagPR, *flagRemote, *flagPRRef, *flagPRBase)
+		if err != nil {
+			log.Fatal(err)
 		}
-		beforeRef = parent
-		afterRef = clHead
 	}
 	switch flag.NArg() {
 	case 0:
 	case 1:
 		beforeRef = flag.Arg(0)
-	case 2:
+	default:
+		// A baseline and one or more candidates.
 		beforeRef = flag.Arg(0)
 		afterRef = flag.Arg(1)
-	default:
-		log.Fatal("usage: compilecmp [before-git-ref] [after-git-ref]")
+	}
+	if *flagDirty || *flagPatch != "" {
+		if *flagDirty && *flagPatch != "" {
+			log.Fatal("-dirty is incompatible with -patch")
+		}
+		if *flagEach || flag.NArg() > 2 {
+			log.Fatal("-dirty and -patch are incompatible with -each and with more than two refs")
+		}
+		if *flagDirty {
+			if flag.NArg() == 2 || *flagCL != "" || *flagPR != 0 {
+				log.Fatal("-dirty compares to the working tree; give at most one ref")
+			}
+			afterRef, err = snapshotDirty()
+		} else {
+			afterRef, err = snapshotPatch(afterRef, *flagPatch)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	refs := []string{beforeRef, afterRef}
+	if flag.NArg() > 2 {
+		refs = flag.Args()
+		if *flagEach {
+			log.Fatal("-each is incompatible with more than two refs")
+		}
+		if *flagDumpSSA != "" {
+			log.Fatal("-dumpssa is incompatible with more than two refs")
+		}
+		if *flagHTML != "" {
+			log.Fatal("-html is incompatible with more than two refs")
+		}
+		if *flagSyms > 0 {
+			log.Fatal("-syms is incompatible with more than two refs")
+		}
+		if *flagFn == "diff" {
+			log.Fatal("-fn=diff is incompatible with more than two refs")
+		}
+		if multiplePlatforms() {
+			log.Fatal("-platforms with more than one platform is incompatible with more than two refs")
+		}
+	}
+	if *flagCSV != "" && !*flagEach {
+		log.Fatal("-csv requires -each")
+	}
+	for _, env := range []string{*flagEnv, *flagBeforeEnv, *flagAfterEnv} {
+		if err := checkEnv(env); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var cond bisectCond
+	if *flagBisect != "" {
+		cond, err = parseBisectCond(*flagBisect)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(refs) != 2 || *flagEach {
+			log.Fatal("-bisect requires exactly two refs (good and bad) and is incompatible with -each")
+		}
+		if multiplePlatforms() {
+			log.Fatal("-bisect works on a single platform")
+		}
+		if *flagJSON {
+			log.Fatal("-bisect is incompatible with -json")
+		}
+		if *flagBeforeEnv != "" || *flagAfterEnv != "" {
+			log.Fatal("-bisect is incompatible with -beforeenv and -afterenv; use -env")
+		}
+		if *flagBeforeFlags != "" || *flagAfterFlags != "" || *flagBeforeLd != "" || *flagAfterLd != "" || *flagBeforeAsm != "" || *flagAfterAsm != "" {
+			log.Fatal("-bisect is incompatible with the before and after flags; use -flags, -ldflags, and -asmflags")
+		}
 	}
 	// Resolve immediately, for two reasons:
 	// catch ref problems early,
 	// and lock in stone the resolution in case the user changes branches
-	resolve(beforeRef)
-	resolve(afterRef)
+	var shas []string
+	for _, ref := range refs {
+		shas = append(shas, resolve(ref))
+	}
+	// With -each, every commit in the range is measured; keep them all cached.
+	var revs []string
+	if *flagEach {
+		list, err := git("rev-list", afterRef, beforeRef+".."+afterRef)
+		check(err)
+		revs = strings.Fields(string(list))
+		shas = append(shas, revs...)
+	}
+
+	cleanCache(shas...)
 
 	switch *flagFn {
-	case "", "all", "changed", "smaller", "bigger", "stats":
+	case "", "all", "changed", "smaller", "bigger", "stats", "diff":
 	case "help":
 		fallthrough
 	default:
@@ -118,10 +248,17 @@ changed: print only functions whose text size has changed
 smaller: print only functions whose text size has gotten smaller
 bigger: print only functions whose text size has gotten bigger
 stats: print only the summary (per package function size total)
+diff: print all functions whose contents have changed, with a diff of their assembly
 help: print this message and exit
 `[1:])
 		os.Exit(2)
 	}
+	if *flagFnRe != "" {
+		fnRegexp, err = regexp.Compile(*flagFnRe)
+		if err != nil {
+			log.Fatalf("bad -fnre: %v", err)
+		}
+	}
 
 	// Clean up unused worktrees to avoid error under the following circumstances:
 	// * run compilecmp ref1 ref2
@@ -129,26 +266,42 @@ help: print this message and exit
 	// * run compilecmp ref1 ref2
 	// git gets confused because it thinks ref1 and ref2 have worktrees.
 	// Pruning fixes that.
+	// Hold the cache lock, so that we don't prune a worktree
+	// that another compilecmp is in the middle of adding.
+	cl := lockCache(true)
 	if _, err := git("worktree", "prune"); err != nil {
 		log.Fatalf("could not prune worktrees: %v", err)
 	}
+	cl.unlock()
+
+	if *flagBisect != "" {
+		bisect(*flagPlatforms, beforeRef, afterRef, cond)
+		return
+	}
+	// Build all the toolchains up front, concurrently.
+	buildWorktrees(append(refs, revs...)...)
 
-	compare(beforeRef, afterRef)
+	reps := compare(refs...)
 	if !*flagEach {
+		if *flagHTML != "" {
+			writeHTMLFile(*flagHTML, reps)
+		}
 		return
 	}
 
-	list, err := git("rev-list", afterRef, beforeRef+".."+afterRef)
-	check(err)
-	revs := strings.Fields(string(list))
+	var series []*report
 	for i := len(revs); i > 0; i-- {
 		before := beforeRef
 		if i < len(revs) {
 			before = revs[i]
 		}
 		after := revs[i-1]
-		fmt.Println("---")
-		compare(before, after)
+		fmt.Fprintln(stdout, "---")
+		series = append(series, compare(before, after)...)
+	}
+	printTrends(series)
+	if *flagHTML != "" {
+		writeHTMLFile(*flagHTML, append(reps, series...))
 	}
 }
 
@@ -164,15 +317,148 @@ func combineFlags(x, y string) string {
 	return x + " " + y
 }
 
+// buildFlags are the extra flags for each tool, for one side of a comparison.
+// They apply to every build of every package.
+type buildFlags struct {
+	gc  string // compiler flags
+	ld  string // linker flags
+	asm string // assembler flags
+}
+
+// sideFlags returns the build flags for the before side (the baseline),
+// or for the after side (the candidates).
+func sideFlags(after bool) buildFlags {
+	if after {
+		return buildFlags{
+			gc:  combineFlags(*flagFlags, *flagAfterFlags),
+			ld:  combineFlags(*flagLdflags, *flagAfterLd),
+			asm: combineFlags(*flagAsmflags, *flagAfterAsm),
+		}
+	}
+	return buildFlags{
+		gc:  combineFlags(*flagFlags, *flagBeforeFlags),
+		ld:  combineFlags(*flagLdflags, *flagBeforeLd),
+		asm: combineFlags(*flagAsmflags, *flagBeforeAsm),
+	}
+}
+
+// args returns go command flags that apply f.
+// gcflags are prefixed by extra, for builds that need compiler flags of their own.
+func (f buildFlags) args(extra string) []string {
+	var args []string
+	if gc := combineFlags(extra, f.gc); gc != "" {
+		args = append(args, "-gcflags=all="+gc)
+	}
+	if f.ld != "" {
+		args = append(args, "-ldflags=all="+f.ld)
+	}
+	if f.asm != "" {
+		args = append(args, "-asmflags=all="+f.asm)
+	}
+	return args
+}
+
+// key returns a string that identifies f, for stored results.
+// For compiler flags alone, it is just the flags.
+func (f buildFlags) key() string {
+	key := f.gc
+	if f.ld != "" {
+		key += "\nldflags: " + f.ld
+	}
+	if f.asm != "" {
+		key += "\nasmflags: " + f.asm
+	}
+	return key
+}
+
+// print prints the flags in f that are set, for side ("before", "after", and so on).
+func (f buildFlags) print(side string) {
+	for _, x := range []struct{ name, flags string }{{"flags", f.gc}, {"ldflags", f.ld}, {"asmflags", f.asm}} {
+		if x.flags != "" {
+			fmt.Fprintf(stdout, "%s %s: %s\n", side, x.name, x.flags)
+		}
+	}
+}
+
+// checkEnv checks environment settings from -env, -beforeenv, or -afterenv.
+func checkEnv(env string) error {
+	settings, err := splitEnv(env)
+	if err != nil {
+		return err
+	}
+	for _, kv := range settings {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			return fmt.Errorf("bad environment setting %q: want KEY=value", kv)
+		}
+	}
+	return nil
+}
+
+// splitEnv splits environment settings from -env, -beforeenv, or -afterenv
+// into KEY=value settings. Settings are separated by spaces.
+// Single or double quotes protect spaces within a setting,
+// as in GOFLAGS='-ldflags=-s -w'; there are no escapes.
+func splitEnv(env string) ([]string, error) {
+	var settings []string
+	var b strings.Builder
+	in := false // in a setting
+	var quote rune
+	for _, r := range env {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			in = true
+		case unicode.IsSpace(r):
+			if in {
+				settings = append(settings, b.String())
+				b.Reset()
+				in = false
+			}
+		default:
+			b.WriteRune(r)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("bad environment settings %q: unterminated %c", env, quote)
+	}
+	if in {
+		settings = append(settings, b.String())
+	}
+	return settings, nil
+}
+
+// envSettings returns the settings in env, which checkEnv has accepted.
+func envSettings(env string) []string {
+	settings, err := splitEnv(env)
+	check(err)
+	return settings
+}
+
+// sideEnv returns the environment settings for the before side (the baseline),
+// or for the after side (the candidates).
+func sideEnv(after bool) string {
+	if after {
+		return combineFlags(*flagEnv, *flagAfterEnv)
+	}
+	return combineFlags(*flagEnv, *flagBeforeEnv)
+}
+
 func printcommit(ref string) {
 	sha := resolve(ref)
 	short := shortsha(sha)
 	// If the user's ref is a prefix of the sha (e.g. they passed a sha),
 	// just show the sha once.
 	if strings.HasPrefix(sha, ref) {
-		fmt.Printf("%s: %s\n", short, commitmessage(sha))
+		fmt.Fprintf(stdout, "%s: %s\n", short, commitmessage(sha))
 	} else {
-		fmt.Printf("%s (%s): %s\n", ref, short, commitmessage(sha))
+		fmt.Fprintf(stdout, "%s (%s): %s\n", ref, short, commitmessage(sha))
 	}
 }
 
@@ -188,103 +474,152 @@ func allPlatforms() []string {
 	return strings.Split(string(out), "\n")
 }
 
-func compare(beforeRef, afterRef string) {
+// archPlatforms returns one platform per architecture.
+// In practice, right now, this means linux/* and js/wasm.
+func archPlatforms() []string {
+	var platforms []string
+	for _, platform := range allPlatforms() {
+		goos, goarch := parsePlatform(platform)
+		if goos == "linux" || goarch == "wasm" {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+// compare compares refs[0] to each of refs[1:] on each selected platform.
+// For two refs, it returns the report for each platform.
+func compare(refs ...string) []*report {
 	var platforms []string
 	switch *flagPlatforms {
 	case "all":
 		platforms = allPlatforms()
 	case "arch":
-		// one platform per architecture
-		// in practice, right now, this means linux/* and js/wasm
-		all := allPlatforms()
-		for _, platform := range all {
-			goos, goarch := parsePlatform(platform)
-			if goos == "linux" || goarch == "wasm" {
-				platforms = append(platforms, platform)
-			}
-		}
+		platforms = archPlatforms()
+	case "variants":
+		platforms = variantPlatforms(archPlatforms())
 	default:
-		platforms = strings.Split(*flagPlatforms, ",")
+		var err error
+		platforms, err = splitPlatforms(*flagPlatforms)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
+	preparePlatforms(platforms, refs)
+	var reps []*report
 	for _, platform := range platforms {
-		comparePlatform(platform, beforeRef, afterRef)
+		if len(refs) == 2 {
+			reps = append(reps, comparePlatform(platform, refs[0], refs[1]))
+		} else {
+			compareMulti(platform, refs)
+		}
+	}
+	if len(reps) > 1 {
+		printPlatformMatrix(stdout, reps)
+		fmt.Fprintln(stdout)
 	}
+	return reps
 }
 
-func comparePlatform(platform, beforeRef, afterRef string) {
-	fmt.Printf("compilecmp %s -> %s\n", beforeRef, afterRef)
+func comparePlatform(platform, beforeRef, afterRef string) *report {
+	fmt.Fprintf(stdout, "compilecmp %s -> %s\n", beforeRef, afterRef)
 	printcommit(beforeRef)
 	printcommit(afterRef)
 
 	if platform != "" {
-		fmt.Printf("platform: %s\n", platform)
+		fmt.Fprintf(stdout, "platform: %s\n", platform)
 	}
-
-	beforeFlags := combineFlags(*flagFlags, *flagBeforeFlags)
-	if beforeFlags != "" {
-		fmt.Printf("before flags: %s\n", beforeFlags)
+	beforeFlags := sideFlags(false)
+	beforeFlags.print("before")
+	afterFlags := sideFlags(true)
+	afterFlags.print("after")
+	beforeEnv := sideEnv(false)
+	if beforeEnv != "" {
+		fmt.Fprintf(stdout, "before env: %s\n", beforeEnv)
 	}
-	afterFlags := combineFlags(*flagFlags, *flagAfterFlags)
-	if afterFlags != "" {
-		fmt.Printf("after flags: %s\n", afterFlags)
+	afterEnv := sideEnv(true)
+	if afterEnv != "" {
+		fmt.Fprintf(stdout, "after env: %s\n", afterEnv)
 	}
 
-	before := worktree(beforeRef)
-	after := worktree(afterRef)
-	if debug {
-		fmt.Printf("before GOROOT: %s\n", before.dir)
-		fmt.Printf("after GOROOT: %s\n", after.dir)
+	rep := &report{
+		Before:         newReportCommit(beforeRef),
+		After:          newReportCommit(afterRef),
+		Platform:       platformName(platform),
+		BeforeFlags:    beforeFlags.gc,
+		AfterFlags:     afterFlags.gc,
+		BeforeLdflags:  beforeFlags.ld,
+		AfterLdflags:   afterFlags.ld,
+		BeforeAsmflags: beforeFlags.asm,
+		AfterAsmflags:  afterFlags.asm,
+		BeforeEnv:      beforeEnv,
+		AfterEnv:       afterEnv,
 	}
 
-	if *flagCount > 0 {
-		fmt.Println()
-		fmt.Println("benchstat", before.tmp.Name(), after.tmp.Name())
-		e := ETA{start: time.Now(), n: *flagCount}
-		e.update(0)
-		for i := 0; i < *flagCount+1; i++ {
-			record := i != 0 // don't record the first run
-			if record {
-				e.update(i - 1)
-			}
-			before.bench(platform, beforeFlags, record)
-			after.bench(platform, afterFlags, record)
-			if record {
-				e.update(i)
-			}
-		}
-		fmt.Println()
-	}
-	check(before.tmp.Close())
-	check(after.tmp.Close())
-	if *flagCount > 0 {
-		cmd := exec.Command("benchstat", before.tmp.Name(), after.tmp.Name())
-		out, err := cmd.CombinedOutput()
-		check(err)
-		fmt.Println(string(out))
-		fmt.Println()
+	commits, bench := prepareCommits(platform, []string{beforeRef, afterRef})
+	before, after := commits[0], commits[1]
+	if debug {
+		fmt.Fprintf(stdout, "before GOROOT: %s\n", before.dir)
+		fmt.Fprintf(stdout, "after GOROOT: %s\n", after.dir)
 	}
-	fmt.Println()
-	if platform != "" {
-		before.cmdgo(platform, "install", "std", "cmd")
-		after.cmdgo(platform, "install", "std", "cmd")
+	if bench != nil {
+		rep.Bench = benchRows(bench[0], bench[1])
+		printBenchRows(stdout, rep.Bench)
+		fmt.Fprintln(stdout)
+		fmt.Fprintln(stdout)
 	}
-	compareBinaries(platform, before, after)
-	fmt.Println()
+	fmt.Fprintln(stdout)
+	compareBinaries(platform, before, after, rep)
+	fmt.Fprintln(stdout)
 	if *flagObj {
-		compareObjectFiles(platform, before, after)
-		fmt.Println()
+		compareObjectFiles(platform, before, after, rep)
+		fmt.Fprintln(stdout)
 	}
 	if *flagFn != "" {
-		compareFunctions(platform, before, after)
-		fmt.Println()
+		compareFunctions(platform, before, after, rep)
+		fmt.Fprintln(stdout)
 	}
 	if *flagDumpSSA != "" {
-		dumpSSA(platform, before, after, *flagDumpSSA)
+		dumpSSA(platform, before, after, *flagDumpSSA, rep)
 	}
 	// todo: notification?
 
 	// Clean the go cache; see golang.org/issue/29561.
 	after.cmdgo("", "clean", "-cache")
+	forgetCompileJobs()
+
+	if *flagJSON {
+		rep.write(os.Stdout)
+	}
+	return rep
+}
+
+// prepareCommits sets up the commits for refs, the first of which is the baseline,
+// for a comparison on platform, and with -n, benchmarks them.
+// The benchmark results are nil without -n.
+func prepareCommits(platform string, refs []string) ([]commit, []*benchResults) {
+	commits := make([]commit, len(refs))
+	ptrs := make([]*commit, len(refs))
+	for i, ref := range refs {
+		commits[i] = worktree(ref)
+		commits[i].env = envSettings(sideEnv(i > 0))
+		commits[i].flags = sideFlags(i > 0)
+		ptrs[i] = &commits[i]
+	}
+	if *flagCount > 0 {
+		fmt.Fprintln(stdout)
+		runBenchmarks(platform, ptrs, *flagCount)
+		fmt.Fprintln(stdout)
+	}
+	var bench []*benchResults
+	for _, c := range commits {
+		check(c.tmp.Close())
+		if *flagCount > 0 {
+			bench = append(bench, parseBenchFile(c.tmp.Name()))
+		}
+		os.Remove(c.tmp.Name())
+	}
+	return commits, bench
 }
 
 const (
@@ -300,13 +635,19 @@ type filesizes struct {
 	totbefore int64
 	totafter  int64
 	haschange bool
+	records   []sizeRecord
 	out       io.Writer
 	w         *tabwriter.Writer
 }
 
 func newFilesizes(out io.Writer) *filesizes {
+	return newSizeTable(out, "file")
+}
+
+// newSizeTable is like newFilesizes, but with a custom heading for the name column.
+func newSizeTable(out io.Writer, heading string) *filesizes {
 	w := tabwriter.NewWriter(out, 8, 8, 1, ' ', 0)
-	fmt.Fprintln(w, "file\tbefore\tafter\tΔ\t%\t")
+	fmt.Fprintf(w, "%s\tbefore\tafter\tΔ\t%%\t\n", heading)
 	sizes := new(filesizes)
 	sizes.w = w
 	sizes.out = out
@@ -317,6 +658,9 @@ func (s *filesizes) add(name string, beforeSize, afterSize int64) {
 	switch {
 	case beforeSize == 0 && afterSize == 0:
 		return
+	}
+	s.records = append(s.records, sizeRecord{Name: name, Before: beforeSize, After: afterSize})
+	switch {
 	case beforeSize == 0:
 		s.totafter += afterSize
 		s.haschange = true
@@ -345,33 +689,84 @@ func (s *filesizes) flush(desc string) {
 	fmt.Fprintf(s.out, "no %s size changes\n", desc)
 }
 
-func compareBinaries(platform string, before, after commit) {
-	sizes := newFilesizes(os.Stdout)
-	goos, goarch := parsePlatform(platform)
-	dirs := []string{"pkg/tool/" + goos + "_" + goarch}
-	if platform != "" {
-		dirs = append(dirs, "bin")
-	}
-	for _, dir := range dirs {
-		beforeMap := readDirSizes(filepath.Join(before.dir, filepath.FromSlash(dir)))
-		afterMap := readDirSizes(filepath.Join(after.dir, filepath.FromSlash(dir)))
-		names := make(map[string]bool, len(beforeMap)+len(afterMap))
-		for n := range beforeMap {
-			names[n] = true
+func compareBinaries(platform string, before, after commit, rep *report) {
+	beforeSizes, beforeHashes, beforeInstalled := binariesFor(platform, before)
+	afterSizes, afterHashes, afterInstalled := binariesFor(platform, after)
+	sizes := newFilesizes(stdout)
+	// changed lists binaries present before and after whose contents changed,
+	// for the section and symbol breakdowns.
+	type binary struct{ name, dir string }
+	var changed []binary
+	var sameSize []string // binaries whose size is unchanged but whose contents differ
+	for _, dir := range binaryDirs(platform) {
+		beforeMap := dirSizes(beforeSizes, dir)
+		afterMap := dirSizes(afterSizes, dir)
+		for _, name := range unionKeys([]map[string]int64{beforeMap, afterMap}) {
+			sizes.add(name, beforeMap[name], afterMap[name])
+			b, a := beforeMap[name], afterMap[name]
+			path := dir + "/" + name
+			if b == 0 || a == 0 || beforeHashes[path] == afterHashes[path] {
+				continue
+			}
+			if b == a {
+				sameSize = append(sameSize, name)
+			}
+			changed = append(changed, binary{name, dir})
 		}
-		for n := range afterMap {
-			names[n] = true
+	}
+	sizes.flush("binary")
+	rep.Binaries = sizes.records
+	rep.ContentChanged = sameSize
+	if len(sameSize) > 0 {
+		fmt.Fprintf(stdout, "same size, different contents: %s\n", strings.Join(sameSize, ", "))
+	}
+	if len(changed) == 0 {
+		return
+	}
+	// The breakdowns need the binaries themselves, not just their stored measurements.
+	if !beforeInstalled {
+		before.install(platform)
+	}
+	if !afterInstalled {
+		after.install(platform)
+	}
+	for _, b := range changed {
+		beforePath := filepath.Join(before.binaryDir(platform, b.dir), b.name)
+		afterPath := filepath.Join(after.binaryDir(platform, b.dir), b.name)
+		compareSections(b.name, beforePath, afterPath, rep)
+		if *flagSyms > 0 {
+			compareSymbols(b.name, beforePath, afterPath, *flagSyms, rep)
 		}
-		sorted := make([]string, 0, len(names))
-		for n := range names {
-			sorted = append(sorted, n)
+	}
+}
+
+// dirSizes returns the entries of sizes, which are keyed by slash-separated path,
+// for the files directly in dir, keyed by file name.
+func dirSizes(sizes map[string]int64, dir string) map[string]int64 {
+	m := make(map[string]int64)
+	for path, size := range sizes {
+		if name, ok := strings.CutPrefix(path, dir+"/"); ok && !strings.Contains(name, "/") {
+			m[name] = size
 		}
-		sort.Strings(sorted)
-		for _, name := range sorted {
-			sizes.add(name, beforeMap[name], afterMap[name])
+	}
+	return m
+}
+
+// binaryDirs returns the slash-separated directories, relative to GOROOT,
+// that contain the binaries built for platform.
+// A commit may build them elsewhere; see commit.binaryDir.
+func binaryDirs(platform string) []string {
+	goos, goarch := parsePlatform(platform)
+	dirs := []string{"pkg/tool/" + goos + "_" + goarch}
+	if platform != "" {
+		if goos == runtime.GOOS && goarch == runtime.GOARCH {
+			dirs = append(dirs, "bin")
+		} else {
+			// Cross-compiled commands are installed in a subdirectory.
+			dirs = append(dirs, "bin/"+goos+"_"+goarch)
 		}
 	}
-	sizes.flush("binary")
+	return dirs
 }
 
 // readDirSizes returns a map from file basename to size for regular files
@@ -398,36 +793,9 @@ func readDirSizes(dir string) map[string]int64 {
 	return result
 }
 
-func compareObjectFiles(platform string, before, after commit) {
-	platformPath := strings.ReplaceAll(platform, "/", "_")
-	rootFor := func(c commit) string {
-		p := filepath.Join(c.dir, "pkg")
-		if platformPath != "" {
-			p = filepath.Join(p, platformPath)
-		}
-		return p
-	}
-	// collect returns a map from path-relative-to-root to file size,
-	// for every ".a" file under root.
-	collect := func(root string) map[string]int64 {
-		result := map[string]int64{}
-		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info.IsDir() || !strings.HasSuffix(path, ".a") || !strings.HasPrefix(path, root) {
-				return nil
-			}
-			result[path[len(root):]] = info.Size()
-			return nil
-		})
-		if err != nil && !os.IsNotExist(err) {
-			check(err)
-		}
-		return result
-	}
-	beforeMap := collect(rootFor(before))
-	afterMap := collect(rootFor(after))
+func compareObjectFiles(platform string, before, after commit, rep *report) {
+	beforeMap := before.exportFiles(platform)
+	afterMap := after.exportFiles(platform)
 	keys := make(map[string]bool, len(beforeMap)+len(afterMap))
 	for k := range beforeMap {
 		keys[k] = true
@@ -440,21 +808,49 @@ func compareObjectFiles(platform string, before, after commit) {
 		sorted = append(sorted, k)
 	}
 	sort.Strings(sorted)
-	sizes := newFilesizes(os.Stdout)
-	for _, suff := range sorted {
-		// suff is of the form /arch/pkg/file.a (when platformPath == "")
-		// or /pkg/file.a (when platformPath is set). Strip the leading slash,
-		// and strip the arch segment if we didn't already scope the walk to one.
-		name := filepath.ToSlash(suff)
-		name = strings.TrimPrefix(name, "/")
-		if platformPath == "" {
-			if i := strings.IndexByte(name, '/'); i >= 0 {
-				name = name[i+1:]
-			}
-		}
-		sizes.add(name, beforeMap[suff], afterMap[suff])
+	sizes := newFilesizes(stdout)
+	for _, pkg := range sorted {
+		sizes.add(pkg+".a", filesize(beforeMap[pkg]), filesize(afterMap[pkg]))
 	}
 	sizes.flush("object file")
+	rep.Objects = sizes.records
+	compareArchiveMembers(sorted, beforeMap, afterMap, rep)
+}
+
+// exportFiles returns a map from import path to package archive
+// for every package in std and cmd, as built for platform.
+// The archives live in the build cache; GOROOT/pkg no longer
+// contains them as of Go 1.20.
+func (c *commit) exportFiles(platform string) map[string]string {
+	cmdgo := filepath.Join(c.dir, "bin", "go")
+	args := append([]string{"list", "-export"}, c.flags.args("")...)
+	args = append(args, "-f", "{{if .Export}}{{.ImportPath}} {{.Export}}{{end}}", "std", "cmd")
+	cmd := exec.Command(cmdgo, args...)
+	cmd.Env = c.environ(platform)
+	cmd.Dir = filepath.Join(c.dir, "src")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		log.Fatalf("%s\n%s: %v", stderr.Bytes(), cmd, err)
+	}
+	return parseExportList(out)
+}
+
+// parseExportList parses the output of exportFiles's go list command,
+// lines of an import path and an archive path separated by a space,
+// into a map from import path to archive.
+// Import paths can't contain spaces; archive paths can.
+func parseExportList(out []byte) map[string]string {
+	result := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		pkg, export, ok := strings.Cut(strings.TrimSuffix(line, "\r"), " ")
+		if !ok || export == "" {
+			continue
+		}
+		result[pkg] = export
+	}
+	return result
 }
 
 func filesize(path string) int64 {
@@ -472,70 +868,39 @@ func check(err error) {
 }
 
 func parsePlatform(platform string) (goos, goarch string) {
-	if platform == "" {
-		return runtime.GOOS, runtime.GOARCH
-	}
-	f := strings.Split(platform, "/")
-	if len(f) != 2 {
-		panic("bad platform: " + platform)
+	goos, goarch, _, err := parsePlatformSpec(platform)
+	if err != nil {
+		panic(err)
 	}
-	return f[0], f[1]
+	return goos, goarch
 }
 
 type commit struct {
-	ref string
-	sha string
-	dir string
-	tmp *os.File
+	ref   string
+	sha   string
+	dir   string
+	tmp   *os.File
+	env   []string   // extra environment settings for every command, from -env
+	flags buildFlags // extra flags for every build, from -flags, -ldflags, and -asmflags
+}
+
+// environ returns the environment for commands that c runs for platform.
+// c's own settings come last, so that they take precedence.
+func (c *commit) environ(platform string) []string {
+	env := append(os.Environ(), platformEnv(platform)...)
+	return append(env, c.env...)
 }
 
 func (c *commit) cmdgo(platform string, args ...string) []byte {
 	cmdgo := filepath.Join(c.dir, "bin", "go")
 	cmd := exec.Command(cmdgo, args...)
-	goos, goarch := parsePlatform(platform)
-	cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch)
+	cmd.Env = c.environ(platform)
 	cmd.Dir = filepath.Join(c.dir, "src")
 	out, err := cmd.CombinedOutput()
 	check(err)
 	return out
 }
 
-func (c *commit) bench(platform, compilerflags string, record bool) {
-	var args []string
-	if !*flagAll {
-		args = append(args, "-short")
-	}
-	if !*flagCPU {
-		args = append(args, "-alloc")
-	}
-	if *flagObj {
-		args = append(args, "-obj")
-	}
-	if *flagPkg != "" {
-		args = append(args, "-pkg", *flagPkg)
-	}
-	if *flagRun != "" {
-		args = append(args, "-run", *flagRun)
-	}
-	if strings.TrimSpace(compilerflags) != "" {
-		args = append(args, "-compileflags", compilerflags)
-	}
-	args = append(args, "-go="+filepath.Join(c.dir, "bin", "go"))
-	cmd := exec.Command("compilebench", args...)
-	path := "PATH=" + filepath.Join(c.dir, "bin")
-	if sz, err := exec.LookPath("size"); err == nil {
-		path += ":" + filepath.Dir(sz)
-	}
-	goos, goarch := parsePlatform(platform)
-	cmd.Env = append(os.Environ(), path, "GOOS="+goos, "GOARCH="+goarch)
-	cmd.Dir = c.dir
-	if record {
-		cmd.Stdout = c.tmp
-	}
-	err := cmd.Run()
-	check(err)
-}
-
 func git(args ...string) ([]byte, error) {
 	cmd := exec.Command("git", args...)
 	cmd.Dir = cwd
@@ -575,15 +940,49 @@ func shortsha(sha string) string {
 }
 
 func worktree(ref string) commit {
-	u, err := user.Current()
+	sha, dest := buildWorktree(ref)
+	tmp, err := os.CreateTemp("", "")
 	check(err)
-	sha := resolve(ref)
-	dest := filepath.Join(u.HomeDir, ".compilecmp", sha)
+	return commit{ref: ref, sha: sha, dir: dest, tmp: tmp}
+}
+
+var (
+	builtMu sync.Mutex
+	built   = map[string]bool{} // shas whose worktrees this process has built
+)
+
+// buildWorktree creates the worktree for ref in the cache if needed,
+// makes sure that its toolchain is built, and returns its sha and directory.
+// Each worktree is built at most once per process.
+// It is safe to call concurrently for different refs.
+func buildWorktree(ref string) (sha, dest string) {
+	sha = resolve(ref)
+	dest = filepath.Join(cacheRoot(), sha)
+	// Hold the worktree lock exclusively while creating it and doing
+	// its initial build, and shared otherwise, while we use the worktree.
+	lock := lockSHA(sha)
+	builtMu.Lock()
+	done := built[sha]
+	builtMu.Unlock()
+	if done {
+		return sha, dest
+	}
+	marker := filepath.Join(dest, builtMarker)
+	if *flagAllBash || !exists(marker) {
+		lock.relock(true)
+		defer lock.relock(false)
+	}
 	if !exists(dest) {
 		if debug {
-			fmt.Printf("cp <%s> %s\n", ref, dest)
+			fmt.Fprintf(stdout, "cp <%s> %s\n", ref, dest)
 		}
-		if _, err := git("worktree", "add", "--detach", dest, ref); err != nil {
+		// git worktree add is not safe to run concurrently in one repository.
+		worktreeAddMu.Lock()
+		cl := lockCache(false)
+		_, err := git("worktree", "add", "--detach", dest, ref)
+		cl.unlock()
+		worktreeAddMu.Unlock()
+		if err != nil {
 			log.Fatalf("could not create worktree for %q (%q): %v", ref, sha, err)
 		}
 	}
@@ -606,22 +1005,26 @@ func worktree(ref string) commit {
 		cmd := exec.Command(args[0], args[1:]...)
 		cmd.Dir = filepath.Join(dest, "src")
 		if debug {
-			fmt.Println(command)
-		}
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Fatalf("%s\n%v", out, err)
+			fmt.Fprintln(stdout, command)
 		}
+		runBuild(cmd, shortsha(sha))
 	}
 	// These deletions are best effort.
 	// See https://github.com/golang/go/issues/31851 for context.
 	os.RemoveAll(filepath.Join(dest, "pkg", "obj"))
 	os.RemoveAll(filepath.Join(dest, "pkg", "bootstrap"))
-	tmp, err := os.CreateTemp("", "")
-	check(err)
-	return commit{ref: ref, sha: sha, dir: dest, tmp: tmp}
+	if *flagAllBash || !exists(marker) {
+		markBuilt(dest)
+	}
+	touchWorktree(dest)
+	builtMu.Lock()
+	built[sha] = true
+	builtMu.Unlock()
+	return sha, dest
 }
 
+var worktreeAddMu sync.Mutex
+
 func exists(path string) bool {
 	// can stat? it exists. good enough.
 	_, err := os.Stat(path)
@@ -634,129 +1037,94 @@ func commitmessage(ref string) []byte {
 	return b
 }
 
-// clHeadAndParent fetches the given CL to local, returns the CL HEAD and its parents commits.
-func clHeadAndParent(cl int) (string, string, error) {
-	clUrlFormat := "https://go-review.googlesource.com/changes/%d/?o=CURRENT_REVISION&o=ALL_COMMITS"
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(fmt.Sprintf(clUrlFormat, cl))
-	if err != nil {
-		return "", "", err
-	}
-	defer resp.Body.Close()
-
-	// Work around https://code.google.com/p/gerrit/issues/detail?id=3540
-	body, err := io.ReadAll(resp.Body)
+// cleanCache deletes unreachable worktrees from the cache,
+// then evicts worktrees beyond the cache limits, except for those for keep.
+func cleanCache(keep ...string) {
+	maxAge, maxSize, err := cacheLimits()
 	if err != nil {
-		return "", "", err
-	}
-	body = bytes.TrimPrefix(body, []byte(")]}'"))
-
-	var parse struct {
-		CurrentRevision string `json:"current_revision"`
-		Revisions       map[string]struct {
-			Fetch struct {
-				HTTP struct {
-					URL string
-					Ref string
-				}
-			}
-			Commit struct {
-				Parents []struct {
-					Commit string
-				}
-			}
-		}
+		log.Fatal(err)
 	}
-
-	if err := json.Unmarshal(body, &parse); err != nil {
-		return "", "", err
-	}
-	parent := ""
-	if len(parse.Revisions[parse.CurrentRevision].Commit.Parents) > 0 {
-		parent = parse.Revisions[parse.CurrentRevision].Commit.Parents[0].Commit
-	}
-
-	ref := parse.Revisions[parse.CurrentRevision].Fetch.HTTP
-
-	if _, err := git("fetch", ref.URL, ref.Ref); err != nil {
-		return "", "", err
-	}
-	return parse.CurrentRevision, parent, nil
-}
-
-func cleanCache() {
-	u, err := user.Current()
-	check(err)
-	root := filepath.Join(u.HomeDir, ".compilecmp")
-	err = os.MkdirAll(root, 0755)
-	check(err)
-	f, err := os.Open(root)
-	check(err)
-	defer f.Close()
-	fis, err := f.Readdir(-1)
+	root := cacheRoot()
+	cl := lockCache(true)
+	defer cl.unlock()
+	fis, err := os.ReadDir(root)
 	check(err)
+	keepSet := make(map[string]bool)
+	for _, sha := range keep {
+		keepSet[sha] = true
+	}
 
-	// Look through ~/.compilecmp for any shas
+	// Look through the cache for any shas
 	// that are no longer contained in any branch, and delete them.
 	// This is the most common way to end up accumulating
-	// lots of junk in .compilecmp.
-	var wg sync.WaitGroup
-	gate := make(chan bool, 10) // gate concurrent calls
+	// lots of junk in the cache.
+	// Asking git which branches contain each sha is slow,
+	// so instead list all commits reachable from any branch,
+	// once per repository.
+	reachable := make(map[string]map[string]bool) // repo -> shas
 	for _, fi := range fis {
-		if !fi.IsDir() {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
 			continue
 		}
-		wg.Add(1)
-		go func(sha string) {
-			defer wg.Done()
-			gate <- true
-			defer func() { <-gate }()
-			wt := filepath.Join(root, sha)
-			cmd := exec.Command("git", "branch", "--contains", sha)
-			cmd.Dir = wt
-			out, err := cmd.CombinedOutput()
-			okToDelete := false
-			if err != nil {
-				if strings.Contains(string(out), "not a git repository") {
-					// partially initialized repo; nuke it
-					okToDelete = true
-				} else {
-					log.Fatalf("%s\n%s$ %s: %v", out, wt, cmd, err)
-				}
-			}
-			s := strings.TrimSpace(string(out))
-			lines := strings.Split(s, "\n")
-			// Decide whether any real branch contains this sha.
-			// `git branch --contains` in a detached worktree always prints a
-			// "* (HEAD detached at X)" or "* (no branch)" marker line for the
-			// worktree itself; that is not a real branch. Real branches appear
-			// as "  name" or "* name" (no parens).
-			hasBranch := false
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if line == "" {
-					continue
-				}
-				if strings.HasPrefix(line, "* (") {
-					continue
-				}
-				hasBranch = true
-				break
+		sha := fi.Name()
+		if keepSet[sha] {
+			continue
+		}
+		// Skip worktrees that another compilecmp is building or using.
+		l := tryLockSHA(sha)
+		if l == nil {
+			continue
+		}
+		wt := filepath.Join(root, sha)
+		okToDelete := false
+		if repo, ok := worktreeRepo(wt); !ok {
+			// partially initialized worktree, or its repo is gone; nuke it
+			okToDelete = true
+		} else {
+			shas, ok := reachable[repo]
+			if !ok {
+				shas = branchCommits(repo)
+				reachable[repo] = shas
 			}
-			if okToDelete || !hasBranch {
-				// OK to delete
-				err := os.RemoveAll(wt)
-				if err != nil {
-					log.Printf("failed to remove unreachable worktree %s: %v", wt, err)
-				}
+			okToDelete = !shas[sha]
+		}
+		if !okToDelete {
+			l.unlock()
+			continue
+		}
+		if err := removeWorktree(root, sha); err != nil {
+			log.Printf("failed to remove unreachable worktree %s: %v", wt, err)
+			l.unlock()
+			continue
+		}
+		l.remove()
+	}
+	// Remove the locks and stored results of worktrees that are gone,
+	// such as those left behind by earlier versions of compilecmp.
+	for _, fi := range fis {
+		sha, ok := strings.CutSuffix(fi.Name(), ".lock")
+		if !ok || sha == "" || exists(filepath.Join(root, sha)) {
+			continue
+		}
+		if l := tryLockSHA(sha); l != nil {
+			l.remove()
+		}
+	}
+	results, _ := os.ReadDir(filepath.Join(root, resultsDir))
+	for _, fi := range results {
+		sha := fi.Name()
+		if keepSet[sha] || exists(filepath.Join(root, sha)) {
+			continue
+		}
+		if l := tryLockSHA(sha); l != nil {
+			if err := os.RemoveAll(filepath.Join(root, resultsDir, sha)); err != nil {
+				log.Printf("failed to remove stored results for %s: %v", sha, err)
 			}
-		}(fi.Name())
+			l.remove()
+		}
 	}
-	wg.Wait()
 
-	// TODO: also look for very old versions?
-	// We could do this by always touching (say) GOROOT/VERSION
-	// every time we use a worktree, and then looking at last mtime.
+	evictCache(root, keepSet, maxAge, maxSize)
 }
 
 type ETA struct {
@@ -777,5 +1145,5 @@ func (e *ETA) update(i int) {
 		eta = time.Now().Add(r).Round(time.Second).Format(time.Kitchen)
 	}
 	digits := int(math.Ceil(math.Log10(float64(e.n + 1))))
-	fmt.Printf("\rcompleted %[1]*d of %d, estimated time remaining %v (ETA %v)      ", digits, i, e.n, remain, eta)
+	fmt.Fprintf(progress, "\rcompleted %[1]*d of %d, estimated time remaining %v (ETA %v)      ", digits, i, e.n, remain, eta)
 }
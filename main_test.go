@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestRepo returns a new git repository, with branch master
+// and no commits, in a temporary directory.
+// It skips the test if git is not installed.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "master")
+	return dir
+}
+
+// runGit runs git with args in dir, and returns its output
+// without surrounding space. Commits made by it have a fixed author.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+		"GIT_COMMITTER_NAME=gopher", "GIT_COMMITTER_EMAIL=gopher@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestBuildFlags(t *testing.T) {
+	f := buildFlags{gc: "-N -l"}
+	if got, want := f.args("-S"), []string{"-gcflags=all=-S -N -l"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+	if got := f.key(); got != "-N -l" {
+		t.Errorf("key = %q, want the compiler flags alone", got)
+	}
+	f = buildFlags{ld: "-s", asm: "-spectre=all"}
+	if got, want := f.args(""), []string{"-ldflags=all=-s", "-asmflags=all=-spectre=all"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+	if (buildFlags{}).key() != "" || f.key() == (buildFlags{asm: "-s", ld: "-spectre=all"}).key() {
+		t.Errorf("keys do not distinguish flags")
+	}
+}
+
+func TestParseExportList(t *testing.T) {
+	out := "bufio /home/gopher/.cache/go-build/3f/3f0a-d\n" +
+		"cmd/compile/internal/ssa /Users/Go Pher/Library/Caches/go-build/9c/9c1e-d\n" +
+		"internal/goarch C:\\Users\\gopher\\AppData\\Local\\go-build\\01\\01ff-d\r\n" +
+		"\n"
+	want := map[string]string{
+		"bufio":                    "/home/gopher/.cache/go-build/3f/3f0a-d",
+		"cmd/compile/internal/ssa": "/Users/Go Pher/Library/Caches/go-build/9c/9c1e-d",
+		"internal/goarch":          `C:\Users\gopher\AppData\Local\go-build\01\01ff-d`,
+	}
+	if got := parseExportList([]byte(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseExportList = %q, want %q", got, want)
+	}
+}
+
+func TestSplitEnv(t *testing.T) {
+	for _, tt := range []struct {
+		env  string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"  GOEXPERIMENT=foo   GODEBUG=x=1 ", []string{"GOEXPERIMENT=foo", "GODEBUG=x=1"}, true},
+		{`GOFLAGS='-ldflags=-s -w' GOAMD64=v3`, []string{"GOFLAGS=-ldflags=-s -w", "GOAMD64=v3"}, true},
+		{`"GOFLAGS=-tags=a -ldflags='-s -w'"`, []string{"GOFLAGS=-tags=a -ldflags='-s -w'"}, true},
+		{`EMPTY='' X=1`, []string{"EMPTY=", "X=1"}, true},
+		{`GOFLAGS='-ldflags=-s -w`, nil, false},
+	} {
+		got, err := splitEnv(tt.env)
+		if !reflect.DeepEqual(got, tt.want) || (err == nil) != tt.ok {
+			t.Errorf("splitEnv(%q) = %q, %v, want %q, ok=%v", tt.env, got, err, tt.want, tt.ok)
+		}
+	}
+	for _, env := range []string{"GOFLAGS='-ldflags=-s -w'", "A=1 B="} {
+		if err := checkEnv(env); err != nil {
+			t.Errorf("checkEnv(%q) = %v, want nil", env, err)
+		}
+	}
+	for _, env := range []string{"GOFLAGS='-ldflags=-s", "A=1 -w", "=1"} {
+		if err := checkEnv(env); err == nil {
+			t.Errorf("checkEnv(%q) = nil, want error", env)
+		}
+	}
+}
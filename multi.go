@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// N-way comparisons measure a baseline and several candidates,
+// and print each table with the candidates side by side,
+// each compared to the baseline.
+
+// A multiReport is the structured result of a single compareMulti run.
+type multiReport struct {
+	Baseline          reportCommit        `json:"baseline"`
+	Candidates        []reportCommit      `json:"candidates"`
+	Platform          string              `json:"platform"`
+	BaselineFlags     string              `json:"baselineFlags,omitempty"`
+	CandidateFlags    string              `json:"candidateFlags,omitempty"`
+	BaselineLdflags   string              `json:"baselineLdflags,omitempty"`
+	CandidateLdflags  string              `json:"candidateLdflags,omitempty"`
+	BaselineAsmflags  string              `json:"baselineAsmflags,omitempty"`
+	CandidateAsmflags string              `json:"candidateAsmflags,omitempty"`
+	BaselineEnv       string              `json:"baselineEnv,omitempty"`
+	CandidateEnv      string              `json:"candidateEnv,omitempty"`
+	Binaries          []multiSizeRecord   `json:"binaries"`
+	Objects           []multiSizeRecord   `json:"objects,omitempty"`
+	TextSizes         []multiSizeRecord   `json:"textSizes,omitempty"` // per package total function text size
+	Functions         []multiSizeRecord   `json:"functions,omitempty"` // named pkg.func
+	Bench             []multiBenchRecords `json:"bench,omitempty"`     // per candidate
+}
+
+// A multiSizeRecord is one row of a multisizes table.
+// A zero size means that the file or function is absent.
+type multiSizeRecord struct {
+	Name       string  `json:"name"`
+	Baseline   int64   `json:"baseline"`
+	Candidates []int64 `json:"candidates"`
+}
+
+// multiBenchRecords are the benchmark comparisons of one candidate to the baseline.
+type multiBenchRecords struct {
+	Candidate string     `json:"candidate"`
+	Rows      []benchRow `json:"rows"`
+}
+
+func (r *multiReport) write(w io.Writer) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	check(enc.Encode(r))
+}
+
+// multisizes is like filesizes, with a column for each of several commits.
+// The first commit is the baseline; each of the others shows its change from it.
+type multisizes struct {
+	tot       []int64
+	haschange bool
+	records   []multiSizeRecord
+	out       io.Writer
+	w         *tabwriter.Writer
+}
+
+func newMultisizes(out io.Writer, heading string, refs []string) *multisizes {
+	w := tabwriter.NewWriter(out, 8, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t", heading, refs[0])
+	for _, ref := range refs[1:] {
+		fmt.Fprintf(w, "%s\tΔ\t%%\t", ref)
+	}
+	fmt.Fprintln(w)
+	return &multisizes{tot: make([]int64, len(refs)), out: out, w: w}
+}
+
+func (s *multisizes) add(name string, sizes []int64) {
+	changed := false
+	zero := true
+	for _, size := range sizes {
+		if size != sizes[0] {
+			changed = true
+		}
+		if size != 0 {
+			zero = false
+		}
+	}
+	if zero {
+		return
+	}
+	s.records = append(s.records, multiSizeRecord{Name: name, Baseline: sizes[0], Candidates: sizes[1:]})
+	for i, size := range sizes {
+		s.tot[i] += size
+	}
+	if !changed {
+		return
+	}
+	s.haschange = true
+	s.row(name, sizes)
+}
+
+func (s *multisizes) row(name string, sizes []int64) {
+	base := sizes[0]
+	fmt.Fprintf(s.w, "%s\t%s\t", name, sizeOrDash(base))
+	for _, size := range sizes[1:] {
+		switch {
+		case size == base:
+			fmt.Fprintf(s.w, "%s\t\t\t", sizeOrDash(size))
+		case base == 0:
+			fmt.Fprintf(s.w, "%d\t%+d\t(added)\t", size, size)
+		case size == 0:
+			fmt.Fprintf(s.w, "-\t%+d\t(removed)\t", -base)
+		default:
+			fmt.Fprintf(s.w, "%d\t%+d\t%+0.3f%%\t", size, size-base, 100*float64(size)/float64(base)-100)
+		}
+	}
+	fmt.Fprintln(s.w)
+}
+
+func sizeOrDash(size int64) string {
+	if size == 0 {
+		return "-"
+	}
+	return fmt.Sprint(size)
+}
+
+func (s *multisizes) flush(desc string) {
+	if s.haschange {
+		s.row("total", s.tot)
+		s.w.Flush()
+		return
+	}
+	fmt.Fprintf(s.out, "no %s size changes\n", desc)
+}
+
+// unionKeys returns the sorted union of the keys of maps.
+func unionKeys[V any](maps []map[string]V) []string {
+	keys := make(map[string]bool)
+	for _, m := range maps {
+		for k := range m {
+			keys[k] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// compareMulti compares refs[0] to each of refs[1:] on platform.
+func compareMulti(platform string, refs []string) {
+	fmt.Fprintf(stdout, "compilecmp %s -> %s\n", refs[0], strings.Join(refs[1:], ", "))
+	for _, ref := range refs {
+		printcommit(ref)
+	}
+	if platform != "" {
+		fmt.Fprintf(stdout, "platform: %s\n", platform)
+	}
+
+	baseFlags := sideFlags(false)
+	baseFlags.print("baseline")
+	candFlags := sideFlags(true)
+	candFlags.print("candidate")
+	baseEnv := sideEnv(false)
+	if baseEnv != "" {
+		fmt.Fprintf(stdout, "baseline env: %s\n", baseEnv)
+	}
+	candEnv := sideEnv(true)
+	if candEnv != "" {
+		fmt.Fprintf(stdout, "candidate env: %s\n", candEnv)
+	}
+
+	rep := &multiReport{
+		Baseline:          newReportCommit(refs[0]),
+		Platform:          platformName(platform),
+		BaselineFlags:     baseFlags.gc,
+		CandidateFlags:    candFlags.gc,
+		BaselineLdflags:   baseFlags.ld,
+		CandidateLdflags:  candFlags.ld,
+		BaselineAsmflags:  baseFlags.asm,
+		CandidateAsmflags: candFlags.asm,
+		BaselineEnv:       baseEnv,
+		CandidateEnv:      candEnv,
+	}
+	for _, ref := range refs[1:] {
+		rep.Candidates = append(rep.Candidates, newReportCommit(ref))
+	}
+
+	commits, bench := prepareCommits(platform, refs)
+	if bench != nil {
+		for i, b := range bench[1:] {
+			rows := benchRows(bench[0], b)
+			rep.Bench = append(rep.Bench, multiBenchRecords{Candidate: refs[i+1], Rows: rows})
+		}
+		printMultiBench(stdout, refs, rep.Bench)
+		fmt.Fprintln(stdout)
+	}
+	fmt.Fprintln(stdout)
+
+	// Binaries.
+	sizes := newMultisizes(stdout, "file", refs)
+	all := make([]map[string]int64, len(commits))
+	for i, c := range commits {
+		all[i], _, _ = binariesFor(platform, c)
+	}
+	for _, dir := range binaryDirs(platform) {
+		maps := make([]map[string]int64, len(commits))
+		for i := range commits {
+			maps[i] = dirSizes(all[i], dir)
+		}
+		for _, name := range unionKeys(maps) {
+			sizes.add(name, column(maps, name))
+		}
+	}
+	sizes.flush("binary")
+	rep.Binaries = sizes.records
+	fmt.Fprintln(stdout)
+
+	if *flagObj {
+		exports := make([]map[string]string, len(commits))
+		for i := range commits {
+			exports[i] = commits[i].exportFiles(platform)
+		}
+		sizes := newMultisizes(stdout, "file", refs)
+		for _, pkg := range unionKeys(exports) {
+			row := make([]int64, len(exports))
+			for i, m := range exports {
+				row[i] = filesize(m[pkg])
+			}
+			sizes.add(pkg+".a", row)
+		}
+		sizes.flush("object file")
+		rep.Objects = sizes.records
+		fmt.Fprintln(stdout)
+	}
+
+	if *flagFn != "" {
+		compareFunctionsMulti(platform, refs, commits, rep)
+		fmt.Fprintln(stdout)
+	}
+
+	// Clean the go cache; see golang.org/issue/29561.
+	commits[0].cmdgo("", "clean", "-cache")
+	forgetCompileJobs()
+
+	if *flagJSON {
+		rep.write(os.Stdout)
+	}
+}
+
+// column returns the values for key in each of maps.
+func column(maps []map[string]int64, key string) []int64 {
+	col := make([]int64, len(maps))
+	for i, m := range maps {
+		col[i] = m[key]
+	}
+	return col
+}
+
+// collectFuncs returns the functions compiled by c for platform,
+// keyed by package and then by function name.
+func collectFuncs(platform string, c commit) map[string]map[string]stextFunc {
+	wait, r := streamDashS(platform, c)
+	ch := make(chan *pkgScanner)
+	go scanDashS(r, []byte(c.sha), ch)
+	pkgs := make(map[string]map[string]stextFunc)
+	for pkg := range ch {
+		pkgs[pkg.Name] = pkg.Funcs
+	}
+	wait()
+	return pkgs
+}
+
+// compareFunctionsMulti is the N-way analog of compareFunctions.
+// It prints a row for each function whose size (or, with -fn=all,
+// contents) differ from the baseline in any candidate.
+func compareFunctionsMulti(platform string, refs []string, commits []commit, rep *multiReport) {
+	all := make([]map[string]map[string]stextFunc, len(commits))
+	done := make(chan bool)
+	for i := range commits {
+		go func(i int) {
+			all[i] = funcsFor(platform, commits[i])
+			done <- true
+		}(i)
+	}
+	for range commits {
+		<-done
+	}
+
+	sizesBuf := new(bytes.Buffer)
+	sizes := newMultisizes(sizesBuf, "file", refs)
+	for _, pkg := range unionKeys(all) {
+		funcs := make([]map[string]stextFunc, len(all))
+		for i := range all {
+			funcs[i] = all[i][pkg]
+		}
+		tot := make([]int64, len(funcs))
+		var w *tabwriter.Writer
+		for _, name := range unionKeys(funcs) {
+			row := make([]int64, len(funcs))
+			base, baseOK := funcs[0][name]
+			changed, show := false, false
+			for i, m := range funcs {
+				f, ok := m[name]
+				if ok {
+					row[i] = int64(f.textsize)
+					tot[i] += int64(f.textsize)
+				}
+				if i == 0 {
+					continue
+				}
+				switch {
+				case ok != baseOK:
+					changed = true
+					show = show || *flagFn != "stats"
+				case !ok:
+				case f.textsize < base.textsize:
+					changed = true
+					show = show || *flagFn != "bigger" && *flagFn != "stats"
+				case f.textsize > base.textsize:
+					changed = true
+					show = show || *flagFn != "smaller" && *flagFn != "stats"
+				case !bytes.Equal(f.bodyhash, base.bodyhash):
+					changed = true
+					show = show || *flagFn == "all"
+				}
+			}
+			if !changed || fnRegexp != nil && !fnRegexp.MatchString(cleanFuncName(name)) {
+				continue
+			}
+			rep.Functions = append(rep.Functions, multiSizeRecord{Name: pkg + "." + cleanFuncName(name), Baseline: row[0], Candidates: row[1:]})
+			if !show {
+				continue
+			}
+			if w == nil {
+				fmt.Fprintf(stdout, "\n%s%s%s%s\n", ansiFgYellow, ansiBold, pkg, ansiReset)
+				w = tabwriter.NewWriter(stdout, 8, 8, 1, ' ', 0)
+				fmt.Fprint(w, "func\t")
+				for _, ref := range refs {
+					fmt.Fprintf(w, "%s\t", ref)
+				}
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "%s\t", cleanFuncName(name))
+			for _, size := range row {
+				fmt.Fprintf(w, "%s\t", sizeOrDash(size))
+			}
+			fmt.Fprintln(w)
+		}
+		if w != nil {
+			w.Flush()
+		}
+		sizes.add(pkg+".s", tot)
+	}
+	sizes.flush("text size")
+	rep.TextSizes = sizes.records
+	fmt.Fprintln(stdout)
+	io.Copy(stdout, sizesBuf)
+}
+
+// printMultiBench prints a table per unit with the baseline median
+// and each candidate's median and change from the baseline.
+func printMultiBench(w io.Writer, refs []string, cands []multiBenchRecords) {
+	type key struct{ name, unit string }
+	var keys []key
+	rows := make(map[key][]*benchRow) // per candidate; nil if missing
+	var units []string
+	seenUnit := make(map[string]bool)
+	for i, cand := range cands {
+		for j := range cand.Rows {
+			r := &cand.Rows[j]
+			k := key{r.Name, r.Unit}
+			if _, ok := rows[k]; !ok {
+				keys = append(keys, k)
+				rows[k] = make([]*benchRow, len(cands))
+			}
+			rows[k][i] = r
+			if !seenUnit[r.Unit] {
+				seenUnit[r.Unit] = true
+				units = append(units, r.Unit)
+			}
+		}
+	}
+	for i, unit := range units {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 8, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "name\t%s %s\t", refs[0], unit)
+		for _, ref := range refs[1:] {
+			fmt.Fprintf(tw, "%s\tΔ\t", ref)
+		}
+		fmt.Fprintln(tw)
+		for _, k := range keys {
+			if k.unit != unit {
+				continue
+			}
+			var base *benchRow
+			for _, r := range rows[k] {
+				if r != nil {
+					base = r
+					break
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t", k.name, formatSummary(base.BeforeMedian, base.BeforeCI, unit))
+			for _, r := range rows[k] {
+				if r == nil {
+					fmt.Fprint(tw, "-\t\t")
+					continue
+				}
+				delta := "~"
+				if r.Significant {
+					delta = fmt.Sprintf("%+.2f%%", r.Delta)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t", formatSummary(r.AfterMedian, r.AfterCI, unit), delta)
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	}
+}
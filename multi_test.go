@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultisizes(t *testing.T) {
+	var buf bytes.Buffer
+	s := newMultisizes(&buf, "file", []string{"base", "a", "b"})
+	s.add("same", []int64{10, 10, 10})
+	s.add("grown", []int64{100, 110, 100})
+	s.add("added", []int64{0, 0, 5})
+	s.add("absent", []int64{0, 0, 0})
+	s.flush("binary")
+
+	want := []multiSizeRecord{
+		{Name: "same", Baseline: 10, Candidates: []int64{10, 10}},
+		{Name: "grown", Baseline: 100, Candidates: []int64{110, 100}},
+		{Name: "added", Baseline: 0, Candidates: []int64{0, 5}},
+	}
+	if !reflect.DeepEqual(s.records, want) {
+		t.Errorf("records = %v, want %v", s.records, want)
+	}
+	out := buf.String()
+	for _, line := range []string{"grown", "added", "total"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output missing %q:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "same") {
+		t.Errorf("output contains unchanged row:\n%s", out)
+	}
+	if !strings.Contains(out, "+10.000%") || !strings.Contains(out, "(added)") {
+		t.Errorf("output missing changes:\n%s", out)
+	}
+
+	buf.Reset()
+	s = newMultisizes(&buf, "file", []string{"base", "a"})
+	s.add("same", []int64{10, 10})
+	s.flush("binary")
+	if got := buf.String(); got != "no binary size changes\n" {
+		t.Errorf("unchanged output = %q", got)
+	}
+}
+
+func TestUnionKeys(t *testing.T) {
+	got := unionKeys([]map[string]int64{{"b": 1, "a": 2}, nil, {"c": 3, "a": 4}})
+	want := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unionKeys = %v, want %v", got, want)
+	}
+}
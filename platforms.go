@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// A platform is goos/goarch, optionally followed by a micro-architecture level,
+// as in linux/amd64/v3, or by comma-separated environment settings,
+// as in linux/arm,GOARM=6. The empty platform is the host.
+// In a list of platforms, a comma followed by a setting continues the previous platform.
+
+// An archVariant is the environment variable that selects
+// an architecture's micro-architecture level, and its levels.
+type archVariant struct {
+	env    string
+	levels []string
+}
+
+var archVariants = map[string]archVariant{
+	"386":      {"GO386", []string{"sse2", "softfloat"}},
+	"amd64":    {"GOAMD64", []string{"v1", "v2", "v3", "v4"}},
+	"arm":      {"GOARM", []string{"5", "6", "7"}},
+	"arm64":    {"GOARM64", []string{"v8.0", "v8.1", "v9.0"}},
+	"mips":     {"GOMIPS", []string{"hardfloat", "softfloat"}},
+	"mipsle":   {"GOMIPS", []string{"hardfloat", "softfloat"}},
+	"mips64":   {"GOMIPS64", []string{"hardfloat", "softfloat"}},
+	"mips64le": {"GOMIPS64", []string{"hardfloat", "softfloat"}},
+	"ppc64":    {"GOPPC64", []string{"power8", "power9", "power10"}},
+	"ppc64le":  {"GOPPC64", []string{"power8", "power9", "power10"}},
+	"riscv64":  {"GORISCV64", []string{"rva20u64", "rva22u64"}},
+}
+
+// splitPlatforms splits a comma-separated list of platforms.
+func splitPlatforms(list string) ([]string, error) {
+	var platforms []string
+	for _, f := range strings.Split(list, ",") {
+		if strings.Contains(f, "=") {
+			if len(platforms) == 0 {
+				return nil, fmt.Errorf("bad platform list %q: setting %s has no platform", list, f)
+			}
+			platforms[len(platforms)-1] += "," + f
+			continue
+		}
+		platforms = append(platforms, f)
+	}
+	for _, platform := range platforms {
+		if _, _, _, err := parsePlatformSpec(platform); err != nil {
+			return nil, err
+		}
+	}
+	return platforms, nil
+}
+
+// parsePlatformSpec parses platform.
+// settings are its extra environment settings, such as GOAMD64=v3.
+func parsePlatformSpec(platform string) (goos, goarch string, settings []string, err error) {
+	if platform == "" {
+		return runtime.GOOS, runtime.GOARCH, nil, nil
+	}
+	spec, rest, _ := strings.Cut(platform, ",")
+	f := strings.Split(spec, "/")
+	if len(f) != 2 && len(f) != 3 || f[0] == "" || f[1] == "" {
+		return "", "", nil, fmt.Errorf("bad platform %q: want goos/goarch, goos/goarch/level, or goos/goarch,KEY=value", platform)
+	}
+	goos, goarch = f[0], f[1]
+	if len(f) == 3 {
+		v, ok := archVariants[goarch]
+		if !ok {
+			return "", "", nil, fmt.Errorf("bad platform %q: %s has no micro-architecture levels", platform, goarch)
+		}
+		settings = append(settings, v.env+"="+f[2])
+	}
+	if rest != "" {
+		for _, kv := range strings.Split(rest, ",") {
+			if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+				return "", "", nil, fmt.Errorf("bad platform %q: bad setting %q", platform, kv)
+			}
+			settings = append(settings, kv)
+		}
+	}
+	return goos, goarch, settings, nil
+}
+
+// platformEnv returns the environment settings that select platform.
+func platformEnv(platform string) []string {
+	goos, goarch, settings, err := parsePlatformSpec(platform)
+	if err != nil {
+		panic(err)
+	}
+	return append([]string{"GOOS=" + goos, "GOARCH=" + goarch}, settings...)
+}
+
+// platformName returns the canonical name of platform, for display and storage.
+// A platform with only a micro-architecture level is named goos/goarch/level.
+func platformName(platform string) string {
+	goos, goarch, settings, err := parsePlatformSpec(platform)
+	if err != nil {
+		panic(err)
+	}
+	name := goos + "/" + goarch
+	if v, ok := archVariants[goarch]; ok && len(settings) == 1 && strings.HasPrefix(settings[0], v.env+"=") {
+		return name + "/" + strings.TrimPrefix(settings[0], v.env+"=")
+	}
+	for _, kv := range settings {
+		name += "," + kv
+	}
+	return name
+}
+
+// isVariant reports whether platform has extra environment settings.
+func isVariant(platform string) bool {
+	_, _, settings, err := parsePlatformSpec(platform)
+	return err == nil && len(settings) > 0
+}
+
+// variantPlatforms expands each of platforms into its micro-architecture levels.
+// Platforms whose architecture has no levels are unchanged.
+func variantPlatforms(platforms []string) []string {
+	var expanded []string
+	for _, platform := range platforms {
+		_, goarch := parsePlatform(platform)
+		v, ok := archVariants[goarch]
+		if !ok {
+			expanded = append(expanded, platform)
+			continue
+		}
+		for _, level := range v.levels {
+			expanded = append(expanded, platform+"/"+level)
+		}
+	}
+	return expanded
+}
+
+// multiplePlatforms reports whether -platforms selects more than one platform.
+func multiplePlatforms() bool {
+	switch *flagPlatforms {
+	case "all", "arch", "variants":
+		return true
+	}
+	platforms, err := splitPlatforms(*flagPlatforms)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return len(platforms) > 1
+}
+
+// separateBinaries reports whether c builds its binaries for platform
+// in their own directory, rather than installing them in GOROOT.
+// That is the case for variants and for comparisons with environment settings
+// or build flags:
+// go install would replace the plain platform's binaries with theirs,
+// and for the host, replace the toolchain itself.
+// It is decided for the whole comparison, not for each side,
+// so that both sides are built the same way: the host's binaries in GOROOT
+// are built by cmd/dist, with settings of its own, such as CGO_ENABLED=0.
+func (c *commit) separateBinaries(platform string) bool {
+	return isVariant(platform) || sideEnv(false) != "" || sideEnv(true) != "" ||
+		sideFlags(false) != (buildFlags{}) || sideFlags(true) != (buildFlags{})
+}
+
+// binaryDir returns the directory that holds c's binaries for platform
+// that go install would put in dir, one of binaryDirs(platform).
+// Separately built binaries are laid out the same way,
+// under GOROOT/pkg/compilecmp/NAME, where NAME identifies the platform,
+// environment settings, and build flags.
+func (c *commit) binaryDir(platform, dir string) string {
+	if !c.separateBinaries(platform) {
+		return filepath.Join(c.dir, filepath.FromSlash(dir))
+	}
+	name := strings.NewReplacer("/", "_", ",", "_").Replace(platformName(platform))
+	if len(c.env) > 0 || c.flags != (buildFlags{}) {
+		h := sha256.Sum256([]byte(strings.Join(c.env, "\n") + "\n\n" + c.flags.key()))
+		name += "_" + hex.EncodeToString(h[:4])
+	}
+	return filepath.Join(c.dir, "pkg", "compilecmp", name, filepath.FromSlash(dir))
+}
+
+// isHost reports whether platform is the host, named or not, without extra settings.
+// make.bash has already installed std and cmd for it.
+func isHost(platform string) bool {
+	goos, goarch, settings, err := parsePlatformSpec(platform)
+	return err == nil && goos == runtime.GOOS && goarch == runtime.GOARCH && len(settings) == 0
+}
+
+// install installs std and cmd for platform, unless it is the host.
+// Installing for the host would replace the toolchain that other platforms
+// are being prepared with concurrently (see preparePlatforms).
+// Separate binaries (see separateBinaries) are built into their own directory instead.
+func (c *commit) install(platform string) {
+	switch {
+	case c.separateBinaries(platform):
+		dirs := binaryDirs(platform)
+		tools := c.binaryDir(platform, dirs[0])
+		args := append([]string{"build", "-o", tools + string(filepath.Separator)}, c.flags.args("")...)
+		c.cmdgo(platform, append(args, "std", "cmd")...)
+		// Like go install, put the go command and gofmt in bin, not with the tools.
+		// The host's are not compared, as they are not installed for it.
+		goos, _ := parsePlatform(platform)
+		for _, name := range []string{"go", "gofmt"} {
+			if goos == "windows" {
+				name += ".exe"
+			}
+			if len(dirs) == 1 {
+				check(os.Remove(filepath.Join(tools, name)))
+				continue
+			}
+			bin := c.binaryDir(platform, dirs[1])
+			check(os.MkdirAll(bin, 0755))
+			check(os.Rename(filepath.Join(tools, name), filepath.Join(bin, name)))
+		}
+	case isHost(platform):
+	default:
+		c.cmdgo(platform, "install", "std", "cmd")
+	}
+}
+
+// preparePlatforms does the slow, platform-specific work for refs concurrently,
+// up to -j platforms at a time: installing std and cmd and measuring the binaries, and with -fn,
+// compiling everything to collect and store its functions.
+// The comparisons that follow then run one platform at a time,
+// so that their output is not interleaved and benchmarks do not compete.
+func preparePlatforms(platforms, refs []string) {
+	if len(platforms) < 2 {
+		return
+	}
+	jobs := *flagJobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan bool, jobs)
+	var wg sync.WaitGroup
+	for _, platform := range platforms {
+		wg.Add(1)
+		go func(platform string) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			buildProgress("%s: preparing", platform)
+			for i, ref := range refs {
+				sha, dir := buildWorktree(ref)
+				c := commit{ref: ref, sha: sha, dir: dir, env: envSettings(sideEnv(i > 0)), flags: sideFlags(i > 0)}
+				binariesFor(platform, c)
+				// -fn=diff needs function bodies, which are not stored.
+				if *flagFn != "" && *flagFn != "diff" {
+					funcsFor(platform, c)
+				}
+			}
+			buildProgress("%s: prepared", platform)
+		}(platform)
+	}
+	wg.Wait()
+}
+
+// A platformTotals summarizes one platform's report, for the platform matrix.
+type platformTotals struct {
+	platform              string
+	binBefore, binAfter   int64
+	textBefore, textAfter int64
+	funcs                 int // number of changed functions; -1 if not measured
+}
+
+func newPlatformTotals(rep *report) platformTotals {
+	t := platformTotals{platform: rep.Platform, funcs: -1}
+	for _, r := range rep.Binaries {
+		t.binBefore += r.Before
+		t.binAfter += r.After
+	}
+	for _, r := range rep.TextSizes {
+		t.textBefore += r.Before
+		t.textAfter += r.After
+	}
+	if *flagFn != "" {
+		t.funcs = len(rep.Functions)
+	}
+	return t
+}
+
+// printPlatformMatrix prints a summary of reps with a row per platform.
+func printPlatformMatrix(w io.Writer, reps []*report) {
+	tw := tabwriter.NewWriter(w, 8, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "platform\tbinaries Δ\t%%\ttext Δ\t%%\tfunctions\t\n")
+	for _, rep := range reps {
+		t := newPlatformTotals(rep)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t", t.platform, totalDelta(t.binBefore, t.binAfter), totalPct(t.binBefore, t.binAfter))
+		if t.funcs < 0 {
+			fmt.Fprintf(tw, "-\t-\t-\t\n")
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t\n", totalDelta(t.textBefore, t.textAfter), totalPct(t.textBefore, t.textAfter), t.funcs)
+	}
+	tw.Flush()
+}
+
+func totalDelta(before, after int64) string {
+	if before == 0 && after == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+d", after-before)
+}
+
+func totalPct(before, after int64) string {
+	if before == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+0.3f%%", 100*float64(after)/float64(before)-100)
+}
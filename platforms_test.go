@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPlatformMatrix(t *testing.T) {
+	reps := []*report{
+		{
+			Platform:  "linux/amd64",
+			Binaries:  []sizeRecord{{Name: "compile", Before: 1000, After: 1010}, {Name: "link", Before: 500, After: 500}},
+			TextSizes: []sizeRecord{{Name: "runtime", Before: 200, After: 190}},
+			Functions: []funcRecord{{Package: "runtime", Name: "f"}, {Package: "runtime", Name: "g"}},
+		},
+		{
+			Platform: "linux/arm64",
+			Binaries: []sizeRecord{{Name: "compile", Before: 800, After: 800}},
+		},
+	}
+
+	defer func(old string) { *flagFn = old }(*flagFn)
+	*flagFn = ""
+	var buf bytes.Buffer
+	printPlatformMatrix(&buf, reps)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), buf.String())
+	}
+	if f := strings.Fields(lines[1]); strings.Join(f, " ") != "linux/amd64 +10 +0.667% - - -" {
+		t.Errorf("amd64 row = %q", lines[1])
+	}
+
+	*flagFn = "changed"
+	buf.Reset()
+	printPlatformMatrix(&buf, reps)
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if f := strings.Fields(lines[1]); strings.Join(f, " ") != "linux/amd64 +10 +0.667% -10 -5.000% 2" {
+		t.Errorf("amd64 row = %q", lines[1])
+	}
+	if f := strings.Fields(lines[2]); strings.Join(f, " ") != "linux/arm64 +0 +0.000% - - 0" {
+		t.Errorf("arm64 row = %q", lines[2])
+	}
+}
+
+func TestSplitPlatforms(t *testing.T) {
+	got, err := splitPlatforms("linux/amd64/v3,linux/arm,GOARM=6,GOARM64=v9.0,darwin/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"linux/amd64/v3", "linux/arm,GOARM=6,GOARM64=v9.0", "darwin/arm64"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("splitPlatforms = %q, want %q", got, want)
+	}
+	for _, bad := range []string{"GOARM=6,linux/arm", "linux", "linux/amd64/v3/x", "js/wasm/v1", "linux/arm,=6"} {
+		if _, err := splitPlatforms(bad); err == nil {
+			t.Errorf("splitPlatforms(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestPlatformSpec(t *testing.T) {
+	tests := []struct {
+		platform string
+		env      string
+		name     string
+		variant  bool
+	}{
+		{"linux/amd64", "GOOS=linux GOARCH=amd64", "linux/amd64", false},
+		{"linux/amd64/v3", "GOOS=linux GOARCH=amd64 GOAMD64=v3", "linux/amd64/v3", true},
+		{"linux/arm,GOARM=6", "GOOS=linux GOARCH=arm GOARM=6", "linux/arm/6", true},
+		{"linux/arm,GOARM=6,GOEXPERIMENT=x", "GOOS=linux GOARCH=arm GOARM=6 GOEXPERIMENT=x", "linux/arm,GOARM=6,GOEXPERIMENT=x", true},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(platformEnv(tt.platform), " "); got != tt.env {
+			t.Errorf("platformEnv(%q) = %q, want %q", tt.platform, got, tt.env)
+		}
+		if got := platformName(tt.platform); got != tt.name {
+			t.Errorf("platformName(%q) = %q, want %q", tt.platform, got, tt.name)
+		}
+		if got := isVariant(tt.platform); got != tt.variant {
+			t.Errorf("isVariant(%q) = %v, want %v", tt.platform, got, tt.variant)
+		}
+	}
+	c := &commit{dir: "/goroot"}
+	if got, want := c.binaryDir("linux/amd64/v3", "pkg/tool/linux_amd64"), filepath.FromSlash("/goroot/pkg/compilecmp/linux_amd64_v3/pkg/tool/linux_amd64"); got != want {
+		t.Errorf("binaryDir = %q, want %q", got, want)
+	}
+	if got, want := c.binaryDir("linux/amd64", "bin"), filepath.FromSlash("/goroot/bin"); got != want {
+		t.Errorf("binaryDir = %q, want %q", got, want)
+	}
+}
+
+// TestBinaryDirSides checks that the binaries compared for both sides
+// are built the same way, even if only one side has settings.
+func TestBinaryDirSides(t *testing.T) {
+	settings := []struct {
+		flag  *string
+		value string
+	}{
+		{flagAfterEnv, "GOEXPERIMENT=foo"},
+		{flagAfterFlags, "-N"},
+		{flagAfterLd, "-s"},
+	}
+	for _, tt := range settings {
+		flag := tt.flag
+		old := *flag
+		*flag = tt.value
+		before := &commit{dir: "/before", env: envSettings(sideEnv(false)), flags: sideFlags(false)}
+		after := &commit{dir: "/after", env: envSettings(sideEnv(true)), flags: sideFlags(true)}
+		for _, c := range []*commit{before, after} {
+			got := c.binaryDir("", "pkg/tool/linux_amd64")
+			if want := filepath.Join(c.dir, "pkg", "compilecmp") + string(filepath.Separator); !strings.HasPrefix(got, want) {
+				t.Errorf("with after setting %s, binaryDir for %s = %q, want a directory in %s", *flag, c.dir, got, want)
+			}
+		}
+		b := strings.TrimPrefix(before.binaryDir("", "pkg/tool/linux_amd64"), "/before")
+		a := strings.TrimPrefix(after.binaryDir("", "pkg/tool/linux_amd64"), "/after")
+		if b == a {
+			t.Errorf("with after setting %s, binaryDir for both sides is GOROOT%s, want different directories", *flag, b)
+		}
+		*flag = old
+	}
+}
+
+func TestVariantPlatforms(t *testing.T) {
+	got := variantPlatforms([]string{"linux/arm", "js/wasm"})
+	want := "linux/arm/5 linux/arm/6 linux/arm/7 js/wasm"
+	if strings.Join(got, " ") != want {
+		t.Errorf("variantPlatforms = %q, want %q", got, want)
+	}
+}
+
+func TestIsHost(t *testing.T) {
+	host := runtime.GOOS + "/" + runtime.GOARCH
+	other := "plan9/386"
+	if host == other {
+		other = "linux/amd64"
+	}
+	for platform, want := range map[string]bool{
+		"":                       true,
+		host:                     true,
+		host + ",GOEXPERIMENT=x": false,
+		other:                    false,
+	} {
+		if got := isHost(platform); got != want {
+			t.Errorf("isHost(%q) = %v, want %v", platform, got, want)
+		}
+	}
+}
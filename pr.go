@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultPRRef is the ref pattern for pull requests on GitHub.
+// N is replaced by the pull request number.
+const defaultPRRef = "refs/pull/N/head"
+
+// prRef returns the ref for pull request pr, given a pattern
+// in which the path element N stands for the pull request number.
+func prRef(pattern string, pr int) (string, error) {
+	elems := strings.Split(pattern, "/")
+	found := false
+	for i, e := range elems {
+		if e == "N" {
+			elems[i] = strconv.Itoa(pr)
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("bad -prref %q: no N path element for the pull request number", pattern)
+	}
+	return strings.Join(elems, "/"), nil
+}
+
+// fetchSHA fetches ref from remote and returns its sha.
+func fetchSHA(remote, ref string) (string, error) {
+	if out, err := git("fetch", remote, ref); err != nil {
+		return "", fmt.Errorf("could not fetch %s from %s: %v\n%s", ref, remote, err, out)
+	}
+	sha, err := git("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return "", err
+	}
+	return string(sha), nil
+}
+
+// prRefs fetches pull request pr and the target branch from remote, and returns
+// the commits to compare: the merge base of the two, and the pull request's head.
+func prRefs(pr int, remote, pattern, target string) (before, after string, err error) {
+	ref, err := prRef(pattern, pr)
+	if err != nil {
+		return "", "", err
+	}
+	head, err := fetchSHA(remote, ref)
+	if err != nil {
+		return "", "", err
+	}
+	base, err := fetchSHA(remote, target)
+	if err != nil {
+		return "", "", err
+	}
+	mergeBase, err := git("merge-base", base, head)
+	if err != nil {
+		return "", "", fmt.Errorf("no merge base for pull request %d and %s: %v", pr, target, err)
+	}
+	return string(mergeBase), head, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestPRRef(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, want string
+	}{
+		{defaultPRRef, "refs/pull/42/head"},
+		{"refs/merge-requests/N/head", "refs/merge-requests/42/head"},
+	} {
+		got, err := prRef(tt.pattern, 42)
+		if err != nil || got != tt.want {
+			t.Errorf("prRef(%q, 42) = %q, %v; want %q", tt.pattern, got, err, tt.want)
+		}
+	}
+	if _, err := prRef("refs/pull/NN/head", 42); err == nil {
+		t.Errorf("prRef with no N element succeeded")
+	}
+}
+
+func TestPRRefs(t *testing.T) {
+	// The forge has master, with a pull request branched from its first commit.
+	forge := newTestRepo(t)
+	runGit(t, forge, "commit", "-q", "--allow-empty", "-m", "base")
+	base := runGit(t, forge, "rev-parse", "HEAD")
+	runGit(t, forge, "commit", "-q", "--allow-empty", "-m", "master moves on")
+	runGit(t, forge, "checkout", "-q", "-b", "feature", "HEAD~1")
+	runGit(t, forge, "commit", "-q", "--allow-empty", "-m", "feature")
+	head := runGit(t, forge, "rev-parse", "HEAD")
+	runGit(t, forge, "update-ref", "refs/pull/7/head", "HEAD")
+
+	local := newTestRepo(t)
+	runGit(t, local, "remote", "add", "forge", forge)
+
+	defer func(old string) { cwd = old }(cwd)
+	cwd = local
+	before, after, err := prRefs(7, "forge", defaultPRRef, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != base || after != head {
+		t.Errorf("prRefs = %s, %s; want %s, %s", before, after, base, head)
+	}
+}
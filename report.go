@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// stdout receives compilecmp's human-readable output.
+// In -json mode it is discarded, and the report is the output.
+var stdout io.Writer = os.Stdout
+
+// progress receives progress updates, such as the benchmark ETA.
+// In -json mode it is stderr, to keep stdout machine-readable.
+var progress io.Writer = os.Stdout
+
+// A report is the structured result of a single comparePlatform run.
+// In -json mode, one report is written to stdout per run.
+type report struct {
+	Before         reportCommit    `json:"before"`
+	After          reportCommit    `json:"after"`
+	Platform       string          `json:"platform"`
+	BeforeFlags    string          `json:"beforeFlags,omitempty"`
+	AfterFlags     string          `json:"afterFlags,omitempty"`
+	BeforeLdflags  string          `json:"beforeLdflags,omitempty"`
+	AfterLdflags   string          `json:"afterLdflags,omitempty"`
+	BeforeAsmflags string          `json:"beforeAsmflags,omitempty"`
+	AfterAsmflags  string          `json:"afterAsmflags,omitempty"`
+	BeforeEnv      string          `json:"beforeEnv,omitempty"`
+	AfterEnv       string          `json:"afterEnv,omitempty"`
+	Binaries       []sizeRecord    `json:"binaries"`
+	ContentChanged []string        `json:"contentChanged,omitempty"` // binaries with unchanged size but different contents
+	Sections       []sectionRecord `json:"sections,omitempty"`       // for binaries whose contents changed
+	Symbols        []symbolRecord  `json:"symbols,omitempty"`        // for binaries whose contents changed, with -syms
+	Objects        []sizeRecord    `json:"objects,omitempty"`
+	ObjectMembers  []sizeRecord    `json:"objectMembers,omitempty"` // package archive members, named pkg/member
+	ObjectKinds    []sizeRecord    `json:"objectKinds,omitempty"`   // archive member totals by kind
+	TextSizes      []sizeRecord    `json:"textSizes,omitempty"`     // per package total function text size
+	Functions      []funcRecord    `json:"functions,omitempty"`
+	Bench          []benchRow      `json:"bench,omitempty"`
+	SSAFiles       []string        `json:"ssaFiles,omitempty"` // ssa.html files written by -dumpssa
+}
+
+type reportCommit struct {
+	Ref     string `json:"ref"`
+	SHA     string `json:"sha"`
+	Subject string `json:"subject"`
+}
+
+func newReportCommit(ref string) reportCommit {
+	sha := resolve(ref)
+	return reportCommit{Ref: ref, SHA: sha, Subject: string(commitmessage(sha))}
+}
+
+// A sizeRecord is one row of a filesizes table.
+// A zero Before or After means that the file was added or removed.
+type sizeRecord struct {
+	Name   string `json:"name"`
+	Before int64  `json:"before"`
+	After  int64  `json:"after"`
+}
+
+// A sectionRecord breaks down the size of a binary by section.
+type sectionRecord struct {
+	Binary   string       `json:"binary"`
+	Sections []sizeRecord `json:"sections"`
+}
+
+// A symbolRecord summarizes the symbol-level changes to a binary.
+// The lists hold only the largest changes.
+type symbolRecord struct {
+	Binary     string       `json:"binary"`
+	TextBefore int64        `json:"textBefore"`
+	TextAfter  int64        `json:"textAfter"`
+	DataBefore int64        `json:"dataBefore"`
+	DataAfter  int64        `json:"dataAfter"`
+	Added      []sizeRecord `json:"added"`
+	Removed    []sizeRecord `json:"removed"`
+	Grown      []sizeRecord `json:"grown"`
+	Shrunk     []sizeRecord `json:"shrunk"`
+}
+
+// A funcRecord describes a function whose generated code changed.
+type funcRecord struct {
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	Change  string `json:"change"`         // inserted, deleted, changed, grown, or shrunk
+	Before  int    `json:"before"`         // text size
+	After   int    `json:"after"`          // text size
+	Diff    string `json:"diff,omitempty"` // unified diff of the assembly, with -fn=diff
+}
+
+// A benchRow summarizes the samples for one benchmark and unit.
+type benchRow struct {
+	Name         string    `json:"name"`
+	Unit         string    `json:"unit"`
+	Before       []float64 `json:"before"`
+	After        []float64 `json:"after"`
+	BeforeMedian float64   `json:"beforeMedian"`
+	AfterMedian  float64   `json:"afterMedian"`
+	BeforeCI     *interval `json:"beforeCI,omitempty"` // confidence interval for the median, if there are enough samples
+	AfterCI      *interval `json:"afterCI,omitempty"`
+	Delta        float64   `json:"delta"` // percent change in median
+	P            float64   `json:"p"`     // Mann-Whitney U test p-value
+	Significant  bool      `json:"significant"`
+}
+
+func (r *report) write(w io.Writer) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	check(enc.Encode(r))
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Measurements are stored in the cache, so that later runs can reuse them.
+// They are stored in root/.results/SHA/KEY.json, where KEY is derived
+// from the platform and compiler flags. They are removed along with
+// the commit's worktree (see removeWorktree).
+//
+// Benchmark samples accumulate: a run with -n N reuses the stored samples
+// that all the commits it compares have, and runs only as many iterations
+// as needed to have N of each.
+// -fresh discards stored results instead of reusing them.
+
+// resultsDir is the name of the directory in the cache root that holds stored results.
+// Its leading dot keeps it from being mistaken for a worktree.
+const resultsDir = ".results"
+
+// results are the stored measurements of a commit for a platform,
+// environment settings, and set of compiler flags.
+type results struct {
+	SHA      string                           `json:"sha"`
+	Platform string                           `json:"platform"`
+	Env      []string                         `json:"env,omitempty"`
+	Flags    string                           `json:"flags,omitempty"`
+	Binaries map[string]int64                 `json:"binaries,omitempty"` // keyed by slash-separated path relative to GOROOT
+	BinHash  map[string]string                `json:"binHash,omitempty"`  // SHA-256 of each binary, keyed like Binaries
+	BinHashV int                              `json:"binHashV,omitempty"` // how BinHash was computed; see binHashVersion
+	BinSep   bool                             `json:"binSep,omitempty"`   // whether the binaries were built separately; see commit.separateBinaries
+	Funcs    map[string]map[string]storedFunc `json:"funcs,omitempty"`    // keyed by package, then function
+	FuncHash int                              `json:"funcHash,omitempty"` // how Funcs' hashes were computed; see funcHashVersion
+	Bench    []string                         `json:"bench,omitempty"`    // lines in Go benchmark format
+}
+
+// funcHashVersion identifies how function body hashes are computed.
+// Stored functions with another version are ignored.
+const funcHashVersion = 3 // hash of normalized -S output, with 6-digit pcs
+
+// binHashVersion identifies how binary hashes are computed.
+// Stored binaries with another version are measured again.
+const binHashVersion = 2 // build ID, Go version, worktree dir, and DWARF masked
+
+// A storedFunc is the stored form of a stextFunc, without its body.
+type storedFunc struct {
+	Size int    `json:"size"`
+	Hash []byte `json:"hash"`
+}
+
+// resultsPath returns the path of the stored results for sha, platform, env, and flags.
+func resultsPath(sha, platform string, env []string, flags string) string {
+	key := platformName(platform) + "\n" + strings.TrimSpace(flags)
+	if len(env) > 0 {
+		key += "\n" + envKey(env)
+	}
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(cacheRoot(), resultsDir, sha, hex.EncodeToString(h[:8])+".json")
+}
+
+// envKey returns a string that identifies the environment settings env.
+// Settings containing spaces are quoted, so that they can't be confused
+// with several settings.
+func envKey(env []string) string {
+	var b strings.Builder
+	for i, kv := range env {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if strings.ContainsFunc(kv, unicode.IsSpace) {
+			kv = strconv.Quote(kv)
+		}
+		b.WriteString(kv)
+	}
+	return b.String()
+}
+
+// loadResults returns the stored results at path,
+// or empty results if there are none.
+func loadResults(path string) *results {
+	r := new(results)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r
+	}
+	check(err)
+	if err := json.Unmarshal(data, r); err != nil {
+		// A corrupt file is no worse than a missing one.
+		fmt.Fprintf(os.Stderr, "ignoring stored results %s: %v\n", path, err)
+		return new(results)
+	}
+	return r
+}
+
+// updateResults applies f to the stored results for sha, platform, env, and flags,
+// and stores them again. It holds a lock while doing so,
+// so that concurrent compilecmps do not lose each other's updates.
+func updateResults(sha, platform string, env []string, flags string, f func(*results)) {
+	path := resultsPath(sha, platform, env, flags)
+	check(os.MkdirAll(filepath.Dir(path), 0755))
+	l := lockFile(path+".lock", true, true)
+	defer l.unlock()
+	r := loadResults(path)
+	r.SHA = sha
+	r.Platform = platformName(platform)
+	r.Env = env
+	r.Flags = strings.TrimSpace(flags)
+	f(r)
+	data, err := json.Marshal(r)
+	check(err)
+	// Write and rename, so that readers never see a partial file.
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	check(os.WriteFile(tmp, data, 0644))
+	check(os.Rename(tmp, path))
+}
+
+// storedResults returns the stored results for sha, platform, env, and flags,
+// or empty results if -fresh is set.
+func storedResults(sha, platform string, env []string, flags string) *results {
+	if *flagFresh {
+		return new(results)
+	}
+	return loadResults(resultsPath(sha, platform, env, flags))
+}
+
+// binariesFor returns the sizes and content hashes of c's binaries for platform,
+// keyed by slash-separated path relative to GOROOT, from stored results if possible.
+// Otherwise it installs the binaries (see commit.install), and stores their measurements.
+// installed reports whether it did so.
+func binariesFor(platform string, c commit) (sizes map[string]int64, hashes map[string]string, installed bool) {
+	r := usableResults(platform, c, savedBinaries)
+	if r.Binaries != nil && r.BinHashV == binHashVersion && r.BinSep == c.separateBinaries(platform) {
+		return r.Binaries, r.BinHash, false
+	}
+	c.install(platform)
+	sizes = make(map[string]int64)
+	hashes = make(map[string]string)
+	for _, dir := range binaryDirs(platform) {
+		bin := c.binaryDir(platform, dir)
+		for name, size := range readDirSizes(bin) {
+			sizes[dir+"/"+name] = size
+			hashes[dir+"/"+name] = binaryHash(filepath.Join(bin, name), c.dir)
+		}
+	}
+	updateResults(c.sha, platform, c.env, c.flags.key(), func(r *results) {
+		r.Binaries = sizes
+		r.BinHash = hashes
+		r.BinHashV = binHashVersion
+		r.BinSep = c.separateBinaries(platform)
+	})
+	markSaved(savedBinaries, resultsPath(c.sha, platform, c.env, c.flags.key()))
+	return sizes, hashes, true
+}
+
+// funcsFor returns the functions compiled by c for platform,
+// from stored results if possible. The bodies are never stored,
+// so it always compiles when they are needed, for -fn=diff.
+func funcsFor(platform string, c commit) map[string]map[string]stextFunc {
+	if *flagFn != "diff" {
+		r := usableResults(platform, c, savedFuncs)
+		if r.Funcs != nil && r.FuncHash == funcHashVersion {
+			pkgs := make(map[string]map[string]stextFunc, len(r.Funcs))
+			for pkg, funcs := range r.Funcs {
+				m := make(map[string]stextFunc, len(funcs))
+				for name, f := range funcs {
+					m[name] = stextFunc{textsize: f.Size, bodyhash: f.Hash}
+				}
+				pkgs[pkg] = m
+			}
+			return pkgs
+		}
+	}
+	pkgs := collectFuncs(platform, c)
+	saveFuncs(platform, c, pkgs)
+	return pkgs
+}
+
+var (
+	savedMu       sync.Mutex
+	savedFuncs    = map[string]bool{} // results paths whose functions this process stored
+	savedBinaries = map[string]bool{} // results paths whose binaries this process stored
+	savedBench    = map[string]bool{} // results paths whose benchmark samples this process stored
+)
+
+// isSaved reports whether path is in saved, one of the sets of results paths above.
+func isSaved(saved map[string]bool, path string) bool {
+	savedMu.Lock()
+	defer savedMu.Unlock()
+	return saved[path]
+}
+
+// markSaved adds path to saved, one of the sets of results paths above.
+func markSaved(saved map[string]bool, path string) {
+	savedMu.Lock()
+	defer savedMu.Unlock()
+	saved[path] = true
+}
+
+// usableResults returns the stored results for c on platform that this run may use.
+// With -fresh, results stored by earlier runs are ignored, but those stored
+// by this process, which saved records, are fresh, so they are used regardless.
+func usableResults(platform string, c commit, saved map[string]bool) *results {
+	path := resultsPath(c.sha, platform, c.env, c.flags.key())
+	if isSaved(saved, path) {
+		return loadResults(path)
+	}
+	return storedResults(c.sha, platform, c.env, c.flags.key())
+}
+
+func saveFuncs(platform string, c commit, pkgs map[string]map[string]stextFunc) {
+	updateResults(c.sha, platform, c.env, c.flags.key(), func(r *results) {
+		r.Funcs = make(map[string]map[string]storedFunc, len(pkgs))
+		r.FuncHash = funcHashVersion
+		for pkg, funcs := range pkgs {
+			m := make(map[string]storedFunc, len(funcs))
+			for name, f := range funcs {
+				m[name] = storedFunc{Size: f.textsize, Hash: f.bodyhash}
+			}
+			r.Funcs[pkg] = m
+		}
+	})
+	markSaved(savedFuncs, resultsPath(c.sha, platform, c.env, c.flags.key()))
+}
+
+// sendFuncs sends pkgs on a channel, in the form produced by scanDashS.
+func sendFuncs(pkgs map[string]map[string]stextFunc) <-chan *pkgScanner {
+	c := make(chan *pkgScanner)
+	go func() {
+		names := make([]string, 0, len(pkgs))
+		for name := range pkgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c <- &pkgScanner{Name: name, Funcs: pkgs[name]}
+		}
+		close(c)
+	}()
+	return c
+}
+
+// benchStored reports whether benchmark results can be stored.
+// Benchmarks of -pkg depend on the working directory, not just the commit.
+func benchStored() bool {
+	return *flagPkg == ""
+}
+
+// storedBench returns the stored samples of each selected benchmark for c,
+// keyed by benchmark name.
+func storedBench(platform string, c *commit) map[string][]string {
+	samples := make(map[string][]string)
+	if !benchStored() {
+		return samples
+	}
+	selected := make(map[string]compileBenchmark)
+	for _, b := range selectedBenchmarks() {
+		selected[b.name] = b
+	}
+	r := usableResults(platform, *c, savedBench)
+	for _, line := range r.Bench {
+		name, line, ok := filterBenchLine(line)
+		b, sel := selected[name]
+		// Samples recorded with -cpu, or without -obj, lack some units.
+		if !ok || !sel || !hasUnits(line, b.units()) {
+			continue
+		}
+		samples[name] = append(samples[name], line)
+	}
+	return samples
+}
+
+// hasUnits reports whether the benchmark result line has a value in each of units.
+func hasUnits(line string, units []string) bool {
+	have := make(map[string]bool)
+	f := strings.Fields(line)
+	for i := 3; i < len(f); i += 2 {
+		have[f[i]] = true
+	}
+	for _, unit := range units {
+		if !have[unit] {
+			return false
+		}
+	}
+	return true
+}
+
+// reuseBench writes stored samples of each selected benchmark to each of commits' tmp files,
+// and returns the number of samples that still need to be run for each benchmark,
+// so that every commit has n.
+// A benchmark's stored samples are used only as far as every commit has them,
+// so that each commit runs the same iterations, interleaved with the others'
+// to cancel out drift in the machine's speed.
+func reuseBench(platform string, commits []*commit, n int) map[string]int {
+	stored := make([]map[string][]string, len(commits))
+	for i, c := range commits {
+		stored[i] = storedBench(platform, c)
+	}
+	need := make(map[string]int)
+	for _, b := range selectedBenchmarks() {
+		have := n
+		for _, s := range stored {
+			have = min(have, len(s[b.name]))
+		}
+		need[b.name] = n - have
+	}
+	for i, c := range commits {
+		total := 0
+		for _, b := range selectedBenchmarks() {
+			for _, line := range stored[i][b.name][:n-need[b.name]] {
+				fmt.Fprintln(c.tmp, line)
+				total++
+			}
+		}
+		if total > 0 {
+			fmt.Fprintf(stdout, "reusing %d stored samples for %s\n", total, c.ref)
+		}
+	}
+	return need
+}
+
+// filterBenchLine parses a stored benchmark result line,
+// and drops the units that this run does not report.
+func filterBenchLine(line string) (name, filtered string, ok bool) {
+	f := strings.Fields(line)
+	if len(f) < 4 || len(f)%2 != 0 || !strings.HasPrefix(f[0], "Benchmark") {
+		return "", "", false
+	}
+	keep := f[:2]
+	for i := 2; i < len(f); i += 2 {
+		switch unit := f[i+1]; {
+		case unit == "peak-RSS-bytes" && *flagCPU, unit == "obj-bytes" && !*flagObj:
+			continue
+		}
+		keep = append(keep, f[i], f[i+1])
+	}
+	return strings.TrimPrefix(f[0], "Benchmark"), strings.Join(keep, " "), true
+}
+
+// saveBench stores the benchmark results in c.tmp after offset,
+// which were recorded by this run. With -fresh, the first save for each commit
+// replaces the samples stored by earlier runs; later ones add to them,
+// as when a commit is compared twice in an -each series.
+func saveBench(platform string, c *commit, offset int64) {
+	if !benchStored() {
+		return
+	}
+	f, err := os.Open(c.tmp.Name())
+	check(err)
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	check(err)
+	var lines []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		lines = append(lines, scan.Text())
+	}
+	check(scan.Err())
+	if len(lines) == 0 {
+		return
+	}
+	path := resultsPath(c.sha, platform, c.env, c.flags.key())
+	updateResults(c.sha, platform, c.env, c.flags.key(), func(r *results) {
+		if *flagFresh && !isSaved(savedBench, path) {
+			r.Bench = nil
+		}
+		r.Bench = append(r.Bench, lines...)
+	})
+	markSaved(savedBench, path)
+}
+
+// runBenchmarks runs the selected benchmarks for each of commits,
+// using its build flags, until each has n samples.
+// Stored samples that all of commits have count towards n (see reuseBench),
+// so only the benchmarks that are short of samples run; new samples are stored.
+// The samples are written to each commit's tmp file.
+func runBenchmarks(platform string, commits []*commit, n int) {
+	need := reuseBench(platform, commits, n) // benchmark name -> samples to run
+	offsets := make([]int64, len(commits))
+	for i, c := range commits {
+		off, err := c.tmp.Seek(0, io.SeekCurrent)
+		check(err)
+		offsets[i] = off
+	}
+	max := 0
+	for _, k := range need {
+		if k > max {
+			max = k
+		}
+	}
+	if max <= 0 {
+		return
+	}
+	e := ETA{start: time.Now(), n: max}
+	e.update(0)
+	for i := 0; i < max+1; i++ {
+		record := i != 0 // don't record the first run
+		if record {
+			e.update(i - 1)
+		}
+		var list []compileBenchmark
+		for _, b := range selectedBenchmarks() {
+			if i <= need[b.name] && need[b.name] > 0 {
+				list = append(list, b)
+			}
+		}
+		for _, c := range commits {
+			c.bench(platform, list, record)
+		}
+		if record {
+			e.update(i)
+		}
+	}
+	for i, c := range commits {
+		saveBench(platform, c, offsets[i])
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateResults(t *testing.T) {
+	defer func(old string) { *flagCache = old }(*flagCache)
+	*flagCache = t.TempDir()
+
+	const sha = "0123456789abcdef"
+	updateResults(sha, "linux/amd64", nil, "-N", func(r *results) {
+		r.Bench = append(r.Bench, "BenchmarkTemplate 1 100 ns/op")
+	})
+	updateResults(sha, "linux/amd64", nil, "-N", func(r *results) {
+		r.Bench = append(r.Bench, "BenchmarkTemplate 1 110 ns/op")
+	})
+	got := storedResults(sha, "linux/amd64", nil, "-N")
+	want := []string{"BenchmarkTemplate 1 100 ns/op", "BenchmarkTemplate 1 110 ns/op"}
+	if !reflect.DeepEqual(got.Bench, want) {
+		t.Errorf("stored bench = %q, want %q", got.Bench, want)
+	}
+	if got.SHA != sha || got.Platform != "linux/amd64" || got.Flags != "-N" {
+		t.Errorf("stored key = %s %s %q", got.SHA, got.Platform, got.Flags)
+	}
+	if r := storedResults(sha, "linux/arm64", nil, "-N"); r.Bench != nil {
+		t.Errorf("results for another platform = %q, want none", r.Bench)
+	}
+	if r := storedResults(sha, "linux/amd64", nil, ""); r.Bench != nil {
+		t.Errorf("results for other flags = %q, want none", r.Bench)
+	}
+	if r := storedResults(sha, "linux/amd64", []string{"GOEXPERIMENT=foo"}, "-N"); r.Bench != nil {
+		t.Errorf("results for other environment = %q, want none", r.Bench)
+	}
+
+	defer func(old bool) { *flagFresh = old }(*flagFresh)
+	*flagFresh = true
+	if r := storedResults(sha, "linux/amd64", nil, "-N"); r.Bench != nil {
+		t.Errorf("results with -fresh = %q, want none", r.Bench)
+	}
+}
+
+func TestReuseBench(t *testing.T) {
+	defer func(old string) { *flagCache = old }(*flagCache)
+	*flagCache = t.TempDir()
+	defer func(old string) { *flagRun = old }(*flagRun)
+	*flagRun = "^(Template|Unicode)$"
+	defer func(cpu, obj bool) { *flagCPU, *flagObj = cpu, obj }(*flagCPU, *flagObj)
+	*flagCPU, *flagObj = true, false
+
+	// The user and system times of each sample.
+	const cpu = " 1 user-ns/op 1 sys-ns/op"
+	const sha1, sha2 = "0123456789abcdef", "fedcba9876543210"
+	updateResults(sha1, "", nil, "", func(r *results) {
+		r.Bench = []string{
+			"BenchmarkTemplate 1 100 ns/op" + cpu + " 5 obj-bytes",
+			"BenchmarkUnicode 1 200 ns/op" + cpu,
+			"BenchmarkTemplate 1 110 ns/op" + cpu,
+			"BenchmarkGoTypes 1 300 ns/op" + cpu,
+		}
+	})
+	updateResults(sha2, "", nil, "", func(r *results) {
+		r.Bench = []string{"BenchmarkTemplate 1 120 ns/op" + cpu}
+	})
+	newCommit := func(sha string) *commit {
+		tmp, err := os.CreateTemp(t.TempDir(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { tmp.Close() })
+		return &commit{sha: sha, tmp: tmp}
+	}
+	samples := func(c *commit) string {
+		data, err := os.ReadFile(c.tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// Only the samples that both commits have are used.
+	c1, c2 := newCommit(sha1), newCommit(sha2)
+	if got, want := reuseBench("", []*commit{c1, c2}, 5), map[string]int{"Template": 4, "Unicode": 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reuseBench needs %v, want %v", got, want)
+	}
+	if got, want := samples(c1), "BenchmarkTemplate 1 100 ns/op"+cpu+"\n"; got != want {
+		t.Errorf("samples of %s:\n%s\nwant:\n%s", sha1, got, want)
+	}
+	if got, want := samples(c2), "BenchmarkTemplate 1 120 ns/op"+cpu+"\n"; got != want {
+		t.Errorf("samples of %s:\n%s\nwant:\n%s", sha2, got, want)
+	}
+
+	// With fewer samples wanted than stored, only that many are used.
+	c1 = newCommit(sha1)
+	if got, want := reuseBench("", []*commit{c1}, 1), map[string]int{"Template": 0, "Unicode": 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reuseBench with n=1 needs %v, want %v", got, want)
+	}
+	if got, want := samples(c1), "BenchmarkTemplate 1 100 ns/op"+cpu+"\nBenchmarkUnicode 1 200 ns/op"+cpu+"\n"; got != want {
+		t.Errorf("samples with n=1:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Samples that lack a unit this run reports are not used.
+	*flagObj = true
+	c1 = newCommit(sha1)
+	if got, want := reuseBench("", []*commit{c1}, 5), map[string]int{"Template": 4, "Unicode": 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reuseBench with -obj needs %v, want %v", got, want)
+	}
+	if got, want := samples(c1), "BenchmarkTemplate 1 100 ns/op"+cpu+" 5 obj-bytes\n"; got != want {
+		t.Errorf("samples with -obj:\n%s\nwant:\n%s", got, want)
+	}
+	if havePeakRSS {
+		*flagCPU, *flagObj = false, false
+		if got, want := reuseBench("", []*commit{newCommit(sha1)}, 5), map[string]int{"Template": 5, "Unicode": 5}; !reflect.DeepEqual(got, want) {
+			t.Errorf("reuseBench without -cpu needs %v, want %v", got, want)
+		}
+	}
+}
+
+func TestStoredBinaries(t *testing.T) {
+	defer func(old string) { *flagCache = old }(*flagCache)
+	*flagCache = t.TempDir()
+
+	const sha = "0123456789abcdef"
+	sizes := map[string]int64{"pkg/tool/linux_arm/compile": 100, "bin/linux_arm/go": 200}
+	hashes := map[string]string{"pkg/tool/linux_arm/compile": "aa", "bin/linux_arm/go": "bb"}
+	updateResults(sha, "linux/arm", nil, "", func(r *results) {
+		r.Binaries = sizes
+		r.BinHash = hashes
+		r.BinHashV = binHashVersion
+	})
+	// The commit has no GOROOT, so installing would fail.
+	c := commit{sha: sha, dir: filepath.Join(t.TempDir(), "missing")}
+	gotSizes, gotHashes, installed := binariesFor("linux/arm", c)
+	if installed {
+		t.Errorf("binariesFor installed the binaries, despite stored results")
+	}
+	if !reflect.DeepEqual(gotSizes, sizes) || !reflect.DeepEqual(gotHashes, hashes) {
+		t.Errorf("binariesFor = %v, %v, want %v, %v", gotSizes, gotHashes, sizes, hashes)
+	}
+	if got, want := dirSizes(gotSizes, "bin"), map[string]int64{}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dirSizes(bin) = %v, want %v", got, want)
+	}
+	if got, want := dirSizes(gotSizes, "bin/linux_arm"), map[string]int64{"go": 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dirSizes(bin/linux_arm) = %v, want %v", got, want)
+	}
+}
+
+func TestEnvKey(t *testing.T) {
+	// Keys of settings without spaces are unchanged from when settings couldn't have spaces.
+	if got := envKey([]string{"GOEXPERIMENT=foo", "GOAMD64=v3"}); got != "GOEXPERIMENT=foo GOAMD64=v3" {
+		t.Errorf("envKey = %q", got)
+	}
+	if envKey([]string{"GOFLAGS=-tags=a GOAMD64=v3"}) == envKey([]string{"GOFLAGS=-tags=a", "GOAMD64=v3"}) {
+		t.Errorf("envKey does not distinguish a setting containing a space from two settings")
+	}
+}
+
+func TestSaveBenchFresh(t *testing.T) {
+	defer func(old string) { *flagCache = old }(*flagCache)
+	*flagCache = t.TempDir()
+	defer func(old bool) { *flagFresh = old }(*flagFresh)
+	*flagFresh = true
+
+	const sha = "0123456789abcdef"
+	updateResults(sha, "", nil, "", func(r *results) {
+		r.Bench = []string{"BenchmarkTemplate 1 90 ns/op"}
+	})
+	tmp, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	c := &commit{sha: sha, tmp: tmp}
+	// The commit is measured twice, as in an -each series.
+	// The first save replaces the earlier run's samples; the second keeps the first's.
+	var offset int64
+	for _, line := range []string{"BenchmarkTemplate 1 100 ns/op\n", "BenchmarkTemplate 1 110 ns/op\n"} {
+		if _, err := tmp.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		saveBench("", c, offset)
+		offset += int64(len(line))
+	}
+	got := loadResults(resultsPath(sha, "", nil, "")).Bench
+	want := []string{"BenchmarkTemplate 1 100 ns/op", "BenchmarkTemplate 1 110 ns/op"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored bench = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// havePeakRSS reports whether peakRSS reports the peak RSS.
+const havePeakRSS = false
+
+// peakRSS returns 0: peak RSS is only available on Unix systems.
+func peakRSS(ps *os.ProcessState) int64 {
+	return 0
+}
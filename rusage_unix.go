@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// havePeakRSS reports whether peakRSS reports the peak RSS.
+const havePeakRSS = true
+
+// peakRSS returns the peak resident set size of the process described by ps,
+// and its waited-for descendants, in bytes.
+func peakRSS(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	switch runtime.GOOS {
+	case "darwin", "ios":
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) * 1024 // kilobytes
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// binaryHash returns the hex-encoded SHA-256 of the executable at path,
+// with its build stamps (see buildStamps), the source directory srcdir,
+// and its debug sections masked, and without a Mach-O code signature,
+// which covers the stamps, so that binaries from different commits
+// hash alike if their code is the same.
+// srcdir is the worktree the executable was built in. Toolchains are built
+// without -trimpath, so its path, which names the commit, is in their file names,
+// as in the -S output that scanDashS reads. The DWARF also holds the file names,
+// usually compressed, where they cannot be masked.
+func binaryHash(path, srcdir string) string {
+	stamps := buildStamps(path)
+	if srcdir != "" {
+		stamps = append(stamps, []byte(srcdir))
+	}
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	var r io.Reader = f
+	if off := codeSignatureOffset(path); off > 0 {
+		// The linker puts the signature at the end of the file.
+		r = io.LimitReader(f, off)
+	}
+	r = &rangeMasker{r: r, ranges: debugRanges(path)}
+	return maskedHash(r, stamps)
+}
+
+// debugRanges returns the file offsets of the start and end
+// of each DWARF section of the executable at path.
+func debugRanges(path string) [][2]int64 {
+	isDebug := func(name string) bool {
+		return strings.HasPrefix(name, ".debug_") || strings.HasPrefix(name, ".zdebug_")
+	}
+	var ranges [][2]int64
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		for _, s := range f.Sections {
+			if isDebug(s.Name) && s.Type != elf.SHT_NOBITS {
+				ranges = append(ranges, [2]int64{int64(s.Offset), int64(s.Offset + s.FileSize)})
+			}
+		}
+		return ranges
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		for _, s := range f.Sections {
+			if s.Seg == "__DWARF" {
+				ranges = append(ranges, [2]int64{int64(s.Offset), int64(s.Offset) + int64(s.Size)})
+			}
+		}
+		return ranges
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		for _, s := range f.Sections {
+			if isDebug(s.Name) {
+				ranges = append(ranges, [2]int64{int64(s.Offset), int64(s.Offset) + int64(s.Size)})
+			}
+		}
+	}
+	return ranges
+}
+
+// A rangeMasker reads from r, with the bytes at the file offsets in ranges zeroed.
+type rangeMasker struct {
+	r      io.Reader
+	off    int64 // offset of the next byte read from r
+	ranges [][2]int64
+}
+
+func (m *rangeMasker) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	for _, rg := range m.ranges {
+		lo, hi := max(rg[0], m.off), min(rg[1], m.off+int64(n))
+		if lo < hi {
+			clear(p[lo-m.off : hi-m.off])
+		}
+	}
+	m.off += int64(n)
+	return n, err
+}
+
+// codeSignatureOffset returns the file offset of the code signature
+// of the Mach-O executable at path, or 0 if it has none.
+func codeSignatureOffset(path string) int64 {
+	f, err := macho.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	for _, l := range f.Loads {
+		const lcCodeSignature = 0x1d
+		if raw := l.Raw(); len(raw) >= 16 && f.ByteOrder.Uint32(raw) == lcCodeSignature {
+			return int64(f.ByteOrder.Uint32(raw[8:]))
+		}
+	}
+	return 0
+}
+
+// buildStamps returns the strings in the executable at path that identify
+// its build rather than its contents, which differ between any two commits:
+// its Go version, its Go build ID, and the IDs that the linker derives from that,
+// the GNU build ID of ELF executables and the UUID of Mach-O executables.
+func buildStamps(path string) [][]byte {
+	var stamps [][]byte
+	if info, err := buildinfo.ReadFile(path); err == nil && info.GoVersion != "" {
+		stamps = append(stamps, []byte(info.GoVersion))
+	}
+	if id := readBuildID(path); id != "" {
+		stamps = append(stamps, []byte(id))
+	}
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		if id := elfNote(f, ".note.gnu.build-id"); len(id) > 0 {
+			stamps = append(stamps, id)
+		}
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		for _, l := range f.Loads {
+			const lcUUID = 0x1b
+			if raw := l.Raw(); len(raw) >= 24 && f.ByteOrder.Uint32(raw) == lcUUID {
+				stamps = append(stamps, raw[8:24])
+			}
+		}
+	}
+	return stamps
+}
+
+// readBuildID returns the Go build ID of the executable at path, or "" if it has none.
+// ELF executables keep it in a note; others at the start of the text,
+// where the linker puts it for every format.
+func readBuildID(path string) string {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		if id := elfNote(f, ".note.go.buildid"); id != nil {
+			return string(id)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	buf := make([]byte, 32<<10)
+	n, _ := io.ReadFull(f, buf)
+	const prefix = "\xff Go build ID: \""
+	_, id, ok := bytes.Cut(buf[:n], []byte(prefix))
+	if !ok {
+		return ""
+	}
+	id, _, ok = bytes.Cut(id, []byte("\"\n \xff"))
+	if !ok {
+		return ""
+	}
+	return string(id)
+}
+
+// elfNote returns the contents of the first note in f's section name,
+// or nil if there is none.
+func elfNote(f *elf.File, name string) []byte {
+	s := f.Section(name)
+	if s == nil {
+		return nil
+	}
+	data, err := s.Data()
+	if err != nil || len(data) < 12 {
+		return nil
+	}
+	// A note is namesz, descsz, and type, followed by the name and the contents,
+	// each padded to a multiple of 4 bytes.
+	namesz := int(f.ByteOrder.Uint32(data))
+	descsz := int(f.ByteOrder.Uint32(data[4:]))
+	off := 12 + (namesz+3)&^3
+	if off+descsz > len(data) {
+		return nil
+	}
+	return data[off : off+descsz]
+}
+
+// maskedHash returns the hex-encoded SHA-256 of r's contents,
+// with every occurrence of each of stamps replaced by zeros.
+func maskedHash(r io.Reader, stamps [][]byte) string {
+	// Hold back enough bytes at the end of each chunk that a stamp
+	// spanning two chunks is masked once the second one is read.
+	keep := 0
+	for _, s := range stamps {
+		if len(s)-1 > keep {
+			keep = len(s) - 1
+		}
+	}
+	h := sha256.New()
+	chunk := make([]byte, 64<<10)
+	var buf []byte
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		eof := err == io.EOF
+		if err != nil && !eof {
+			check(err)
+		}
+		for _, s := range stamps {
+			if len(s) == 0 {
+				continue
+			}
+			for i := 0; ; {
+				j := bytes.Index(buf[i:], s)
+				if j < 0 {
+					break
+				}
+				clear(buf[i+j : i+j+len(s)])
+				i += j + len(s)
+			}
+		}
+		out := len(buf) - keep
+		if eof {
+			out = len(buf)
+		}
+		if out > 0 {
+			h.Write(buf[:out])
+			buf = append(buf[:0], buf[out:]...)
+		}
+		if eof {
+			return hex.EncodeToString(h.Sum(nil))
+		}
+	}
+}
+
+// readSections returns a map from section name to size for the executable at path,
+// which may be an ELF, Mach-O, or PE file.
+// Mach-O section names are converted to their ELF equivalents
+// (__text to .text), so that binaries for different platforms line up.
+func readSections(path string) (map[string]int64, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		m := make(map[string]int64)
+		for _, s := range f.Sections {
+			if s.Name == "" {
+				continue
+			}
+			m[s.Name] += int64(s.Size)
+		}
+		return m, nil
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		m := make(map[string]int64)
+		for _, s := range f.Sections {
+			m["."+strings.TrimPrefix(s.Name, "__")] += int64(s.Size)
+		}
+		return m, nil
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		m := make(map[string]int64)
+		for _, s := range f.Sections {
+			// Size is rounded up to the file alignment,
+			// and is zero for uninitialized data.
+			size := int64(s.VirtualSize)
+			if size == 0 {
+				size = int64(s.Size)
+			}
+			m[s.Name] += size
+		}
+		return m, nil
+	}
+	return nil, errors.New("unrecognized executable format")
+}
+
+// compareSections prints a per-section size table for a binary
+// present in both before and after, and records it in rep.
+func compareSections(name, beforePath, afterPath string, rep *report) {
+	beforeMap, err := readSections(beforePath)
+	if err != nil {
+		// Not every file in a tool directory is an executable.
+		return
+	}
+	afterMap, err := readSections(afterPath)
+	if err != nil {
+		return
+	}
+	keys := make(map[string]bool, len(beforeMap)+len(afterMap))
+	for k := range beforeMap {
+		keys[k] = true
+	}
+	for k := range afterMap {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	fmt.Fprintf(stdout, "\n%s%s%s%s\n", ansiFgYellow, ansiBold, name, ansiReset)
+	sizes := newSizeTable(stdout, "section")
+	for _, sect := range sorted {
+		sizes.add(sect, beforeMap[sect], afterMap[sect])
+	}
+	sizes.flush("section")
+	rep.Sections = append(rep.Sections, sectionRecord{Binary: name, Sections: sizes.records})
+}
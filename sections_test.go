@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadSections(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	m, err := readSections(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m[".text"] == 0 {
+		t.Errorf("no .text section in %s: %v", exe, m)
+	}
+	if _, err := readSections("sections_test.go"); err == nil {
+		t.Errorf("readSections of a non-executable succeeded")
+	}
+}
+
+func TestMaskedHash(t *testing.T) {
+	stamp := []byte("devel go1.99-abc")
+	data := func(fill byte) []byte {
+		// Straddle the 64 KiB chunk boundary.
+		b := bytes.Repeat([]byte("x"), 64<<10-5)
+		b = append(b, bytes.Repeat([]byte{fill}, len(stamp))...)
+		return append(b, "tail"...)
+	}
+	a, b := data('a'), data('b')
+	copy(a[64<<10-5:], stamp)
+	copy(b[64<<10-5:], bytes.Repeat([]byte{0}, len(stamp)))
+	if maskedHash(bytes.NewReader(a), [][]byte{stamp}) != maskedHash(bytes.NewReader(b), nil) {
+		t.Errorf("stamp spanning chunks was not masked")
+	}
+	if maskedHash(bytes.NewReader(a), nil) == maskedHash(bytes.NewReader(b), nil) {
+		t.Errorf("different contents hash alike")
+	}
+}
+
+func TestRangeMasker(t *testing.T) {
+	r := &rangeMasker{r: iotest.OneByteReader(strings.NewReader("abcdefgh")), ranges: [][2]int64{{1, 3}, {6, 20}}}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\x00\x00def\x00\x00"; string(got) != want {
+		t.Errorf("masked = %q, want %q", got, want)
+	}
+}
+
+func TestBinaryHashBuildID(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds executables")
+	}
+	// The same program is built in two directories whose names have the same length,
+	// like worktrees, and must hash alike once each directory is masked.
+	// Compressed DWARF holding the two names could differ in size,
+	// and only binaries of the same size are hashed, so it is not compressed.
+	dir := t.TempDir()
+	srcA, srcB := filepath.Join(dir, "srcaaaa"), filepath.Join(dir, "srcbbbb")
+	write := func(dir, name, src string) string {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	xa := write(srcA, "x.go", "package main\n\nfunc main() { println(1) }\n")
+	xb := write(srcB, "x.go", "package main\n\nfunc main() { println(1) }\n")
+	y := write(srcA, "y.go", "package main\n\nfunc main() { println(2) }\n")
+	for _, platform := range []string{"linux/amd64", "darwin/arm64", "windows/amd64"} {
+		goos, goarch := parsePlatform(platform)
+		build := func(name, buildID, src string) string {
+			exe := filepath.Join(dir, goos+"_"+goarch+"_"+name)
+			cmd := exec.Command("go", "build", "-o", exe, "-ldflags=-compressdwarf=false -buildid="+buildID, src)
+			cmd.Dir = filepath.Dir(src)
+			cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%s: %v\n%s", platform, err, out)
+			}
+			return exe
+		}
+		a := build("a", "aaaaaaaa/aaaaaaaa", xa)
+		b := build("b", "bbbbbbbb/bbbbbbbb", xb)
+		c := build("c", "aaaaaaaa/aaaaaaaa", y)
+		if id := readBuildID(a); id != "aaaaaaaa/aaaaaaaa" {
+			t.Errorf("%s: build ID = %q, want aaaaaaaa/aaaaaaaa", platform, id)
+		}
+		if binaryHash(a, "") == binaryHash(b, "") {
+			t.Errorf("%s: binaries built in different directories hash alike without masking them", platform)
+		}
+		if binaryHash(a, srcA) != binaryHash(b, srcB) {
+			t.Errorf("%s: binaries differing only in build ID and directory hash differently", platform)
+		}
+		if binaryHash(a, srcA) == binaryHash(c, srcA) {
+			t.Errorf("%s: different programs hash alike", platform)
+		}
+	}
+}
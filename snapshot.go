@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// A snapshot is a commit made by compilecmp to measure uncommitted changes:
+// either the working tree (-dirty) or a patch applied to a ref (-patch).
+// Its author, committer, dates, and message are fixed, so its sha depends only
+// on its contents and parent. Measuring the same changes again thus reuses
+// the cached worktree. Each snapshot has a ref under snapshotRefs,
+// so that neither cleanCache nor git gc removes it.
+// The ref is deleted when the snapshot's worktree is evicted or removed
+// (see removeWorktree).
+
+// snapshotRefs is the ref namespace for snapshots.
+const snapshotRefs = "refs/compilecmp/snapshots/"
+
+// snapshotMessage is the commit message of every snapshot.
+const snapshotMessage = "compilecmp snapshot of uncommitted changes"
+
+// gitEnv is like git, with extra environment variables.
+func gitEnv(env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = cwd
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v\n%s", cmd.Args, err, stderr.Bytes())
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+// snapshotCommit returns a snapshot commit with tree and parent.
+func snapshotCommit(tree, parent string) (string, error) {
+	const who = "compilecmp"
+	const when = "1970-01-01T00:00:00Z"
+	env := []string{
+		"GIT_AUTHOR_NAME=" + who, "GIT_AUTHOR_EMAIL=" + who, "GIT_AUTHOR_DATE=" + when,
+		"GIT_COMMITTER_NAME=" + who, "GIT_COMMITTER_EMAIL=" + who, "GIT_COMMITTER_DATE=" + when,
+	}
+	sha, err := gitEnv(env, "commit-tree", tree, "-p", parent, "-m", snapshotMessage)
+	if err != nil {
+		return "", err
+	}
+	if _, err := gitEnv(nil, "update-ref", snapshotRefs+string(sha), string(sha)); err != nil {
+		return "", err
+	}
+	return string(sha), nil
+}
+
+// snapshotDirty returns a snapshot of the tracked files in the working tree,
+// on top of HEAD. Untracked files are not included.
+func snapshotDirty() (string, error) {
+	head, err := gitEnv(nil, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	stash, err := gitEnv(nil, "stash", "create")
+	if err != nil {
+		return "", err
+	}
+	if len(stash) == 0 {
+		return "", errors.New("-dirty: the working tree has no uncommitted changes")
+	}
+	tree, err := gitEnv(nil, "rev-parse", string(stash)+"^{tree}")
+	if err != nil {
+		return "", err
+	}
+	return snapshotCommit(string(tree), string(head))
+}
+
+// snapshotPatch returns a snapshot of the patch file applied to ref.
+// The working tree and index are not modified.
+func snapshotPatch(ref, patch string) (string, error) {
+	patch, err := filepath.Abs(patch)
+	if err != nil {
+		return "", err
+	}
+	base, err := gitEnv(nil, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	// Apply the patch in a temporary index.
+	dir, err := os.MkdirTemp("", "compilecmp-patch-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(dir, "index")}
+	if _, err := gitEnv(env, "read-tree", string(base)); err != nil {
+		return "", err
+	}
+	if _, err := gitEnv(env, "apply", "--cached", patch); err != nil {
+		return "", fmt.Errorf("could not apply %s to %s: %v", patch, ref, err)
+	}
+	tree, err := gitEnv(env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return snapshotCommit(string(tree), string(base))
+}
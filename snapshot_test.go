@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	repo := newTestRepo(t)
+	run := func(args ...string) string { return runGit(t, repo, args...) }
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.go", "package a\n")
+	run("add", "a.go")
+	run("commit", "-q", "-m", "initial")
+	head := run("rev-parse", "HEAD")
+
+	defer func(old string) { cwd = old }(cwd)
+	cwd = repo
+
+	if _, err := snapshotDirty(); err == nil {
+		t.Errorf("snapshotDirty of clean tree succeeded")
+	}
+
+	write("a.go", "package a\n\nconst X = 1\n")
+	dirty, err := snapshotDirty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := snapshotDirty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirty != again {
+		t.Errorf("snapshots of the same working tree differ: %s, %s", dirty, again)
+	}
+	if got := run("show", dirty+":a.go"); !strings.Contains(got, "const X = 1") {
+		t.Errorf("snapshot contents = %q", got)
+	}
+	if got := run("rev-parse", dirty+"^"); got != head {
+		t.Errorf("snapshot parent = %s, want HEAD %s", got, head)
+	}
+
+	// A patch with the same change yields the same snapshot.
+	patch := filepath.Join(t.TempDir(), "x.diff")
+	if err := os.WriteFile(patch, []byte(run("diff")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("checkout", "a.go")
+	patched, err := snapshotPatch("HEAD", patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched != dirty {
+		t.Errorf("patch snapshot %s differs from working tree snapshot %s", patched, dirty)
+	}
+	if run("status", "--porcelain") != "" {
+		t.Errorf("snapshotPatch modified the working tree or index")
+	}
+
+	if !branchCommits(filepath.Join(repo, ".git"))[dirty] {
+		t.Errorf("branchCommits does not include snapshot")
+	}
+
+	// Removing the snapshot's worktree deletes its ref, and prunes the worktree.
+	root := t.TempDir()
+	run("worktree", "add", "-q", "--detach", filepath.Join(root, dirty), dirty)
+	if err := removeWorktree(root, dirty); err != nil {
+		t.Fatal(err)
+	}
+	if refs := run("for-each-ref", snapshotRefs); refs != "" {
+		t.Errorf("after removeWorktree, snapshot refs = %q", refs)
+	}
+	if list := run("worktree", "list", "--porcelain"); strings.Contains(list, dirty) {
+		t.Errorf("after removeWorktree, git still lists the worktree:\n%s", list)
+	}
+	if branchCommits(filepath.Join(repo, ".git"))[dirty] {
+		t.Errorf("branchCommits includes removed snapshot")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// alpha is the significance level for comparing benchmark samples.
+const alpha = 0.05
+
+// confidence is the confidence level for median confidence intervals.
+const confidence = 0.95
+
+func median(x []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), x...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// geomean returns the geometric mean of x, which must be positive.
+func geomean(x []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range x {
+		if v <= 0 {
+			return 0
+		}
+		sum += math.Log(v)
+	}
+	return math.Exp(sum / float64(len(x)))
+}
+
+// An interval is a closed range of values.
+type interval struct {
+	Lo float64 `json:"lo"`
+	Hi float64 `json:"hi"`
+}
+
+// medianCI returns a distribution-free confidence interval for the median of x.
+// It uses order statistics, so there must be enough samples to achieve
+// the requested confidence (six, for 95%); ok reports whether there were.
+func medianCI(x []float64, confidence float64) (ci interval, ok bool) {
+	n := len(x)
+	if n == 0 {
+		return interval{}, false
+	}
+	s := append([]float64(nil), x...)
+	sort.Float64s(s)
+	// The interval [s[k], s[n-1-k]] covers the median with probability
+	// 1 - 2*P(Binomial(n, 1/2) <= k). Find the narrowest such interval.
+	k := -1
+	for i := 0; i < n/2; i++ {
+		if 1-2*binomCDF(i, n) < confidence {
+			break
+		}
+		k = i
+	}
+	if k < 0 {
+		return interval{}, false
+	}
+	return interval{Lo: s[k], Hi: s[n-1-k]}, true
+}
+
+// binomCDF returns P(X <= k) for X ~ Binomial(n, 1/2).
+func binomCDF(k, n int) float64 {
+	var sum float64
+	for i := 0; i <= k; i++ {
+		sum += math.Exp(lchoose(n, i) - float64(n)*math.Ln2)
+	}
+	return sum
+}
+
+func lchoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U test
+// for the null hypothesis that x and y are drawn from the same distribution.
+// It uses the exact distribution of U for small samples without ties,
+// and the normal approximation (with tie correction) otherwise.
+func mannWhitneyU(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	// Rank the combined samples, giving tied values their average rank.
+	type obs struct {
+		v     float64
+		fromX bool
+	}
+	all := make([]obs, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range y {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	var rankSumX, tieCorrection float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // average of 1-based ranks i+1..j
+		for _, o := range all[i:j] {
+			if o.fromX {
+				rankSumX += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieCorrection += t*t*t - t
+		}
+		i = j
+	}
+	u := rankSumX - float64(n1*(n1+1))/2
+
+	if !ties && n1 <= 20 && n2 <= 20 {
+		dist := uDist(n1, n2)
+		var le, ge float64
+		ui := int(u)
+		for i, p := range dist {
+			if i <= ui {
+				le += p
+			}
+			if i >= ui {
+				ge += p
+			}
+		}
+		return math.Min(1, 2*math.Min(le, ge))
+	}
+
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - tieCorrection/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := math.Max(0, math.Abs(u-mu)-0.5) / sigma
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// uDist returns the exact null distribution of the Mann-Whitney U statistic
+// for samples of size n1 and n2 without ties: uDist(n1, n2)[u] = P(U = u).
+func uDist(n1, n2 int) []float64 {
+	// counts[i][j][u] is the number of orderings of i x's and j y's
+	// in which u (x, y) pairs have x > y. The largest element is either
+	// an x, which exceeds all j y's, or a y, which exceeds no x.
+	counts := make([][][]float64, n1+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n2+1)
+		for j := range counts[i] {
+			c := make([]float64, i*j+1)
+			switch {
+			case i == 0 || j == 0:
+				c[0] = 1
+			default:
+				for u := range c {
+					if u-j >= 0 && u-j < len(counts[i-1][j]) {
+						c[u] += counts[i-1][j][u-j]
+					}
+					if u < len(counts[i][j-1]) {
+						c[u] += counts[i][j-1][u]
+					}
+				}
+			}
+			counts[i][j] = c
+		}
+	}
+	dist := counts[n1][n2]
+	total := math.Exp(lchoose(n1+n2, n1))
+	p := make([]float64, len(dist))
+	for u, c := range dist {
+		p[u] = c / total
+	}
+	return p
+}
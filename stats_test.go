@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMedian(t *testing.T) {
+	cases := []struct {
+		in   []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+	for _, test := range cases {
+		if got := median(test.in); got != test.want {
+			t.Errorf("median(%v)=%v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestMedianCI(t *testing.T) {
+	if _, ok := medianCI([]float64{1, 2, 3, 4, 5}, 0.95); ok {
+		t.Errorf("medianCI of 5 samples succeeded; want too few samples")
+	}
+	ci, ok := medianCI([]float64{6, 1, 5, 2, 4, 3}, 0.95)
+	if want := (interval{1, 6}); !ok || ci != want {
+		t.Errorf("medianCI of 6 samples = %v, %v, want %v", ci, ok, want)
+	}
+	ci, ok = medianCI([]float64{10, 1, 9, 2, 8, 3, 7, 4, 6, 5}, 0.95)
+	if want := (interval{2, 9}); !ok || ci != want {
+		t.Errorf("medianCI of 10 samples = %v, %v, want %v", ci, ok, want)
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	cases := []struct {
+		x, y []float64
+		want float64
+	}{
+		// Exact: complete separation of 5+5 samples is 1 of C(10,5) orderings, in each direction.
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		{[]float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 2.0 / 252},
+		// Exact: interleaved samples.
+		{[]float64{1, 3, 5}, []float64{2, 4, 6}, 0.7},
+		// Identical samples.
+		{[]float64{1, 1, 1}, []float64{1, 1, 1}, 1},
+	}
+	for _, test := range cases {
+		if got := mannWhitneyU(test.x, test.y); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("mannWhitneyU(%v, %v)=%v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+	// Normal approximation, with ties.
+	x := []float64{1, 2, 2, 3, 3, 3, 4, 4, 5, 5}
+	y := []float64{5, 6, 6, 7, 7, 7, 8, 8, 9, 9}
+	if p := mannWhitneyU(x, y); p > 0.001 {
+		t.Errorf("mannWhitneyU of separated tied samples = %v, want < 0.001", p)
+	}
+}
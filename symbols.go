@@ -0,0 +1,207 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+)
+
+// A symbol is a sized symbol in an executable.
+type symbol struct {
+	size int64
+	text bool // in an executable section
+}
+
+// readSymbols returns the symbols in the executable at path,
+// which may be an ELF, Mach-O, or PE file, keyed by name.
+// Mach-O and PE symbol tables do not record sizes, so (like go tool nm)
+// we use the distance to the next symbol in the same section.
+func readSymbols(path string) (map[string]symbol, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		syms, err := f.Symbols()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]symbol)
+		for _, s := range syms {
+			if s.Size == 0 || s.Section <= elf.SHN_UNDEF || s.Section >= elf.SHN_LORESERVE || int(s.Section) >= len(f.Sections) {
+				continue
+			}
+			sect := f.Sections[s.Section]
+			sym := m[s.Name]
+			sym.size += int64(s.Size)
+			sym.text = sect.Flags&elf.SHF_EXECINSTR != 0
+			m[s.Name] = sym
+		}
+		return m, nil
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		if f.Symtab == nil {
+			return nil, errors.New("no symbol table")
+		}
+		var addrs []addrSym
+		for _, s := range f.Symtab.Syms {
+			const stab = 0xe0
+			if s.Type&stab != 0 || s.Sect == 0 || int(s.Sect) > len(f.Sections) {
+				continue
+			}
+			sect := f.Sections[s.Sect-1]
+			addrs = append(addrs, addrSym{
+				name: s.Name,
+				sect: int(s.Sect),
+				addr: s.Value,
+				end:  sect.Addr + sect.Size,
+				text: sect.Seg == "__TEXT" && sect.Name == "__text",
+			})
+		}
+		return sizeByAddr(addrs), nil
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		var addrs []addrSym
+		for _, s := range f.Symbols {
+			if s.SectionNumber <= 0 || int(s.SectionNumber) > len(f.Sections) {
+				continue
+			}
+			sect := f.Sections[s.SectionNumber-1]
+			const imageScnCntCode = 0x20
+			addrs = append(addrs, addrSym{
+				name: s.Name,
+				sect: int(s.SectionNumber),
+				addr: uint64(s.Value),
+				end:  uint64(sect.VirtualSize),
+				text: sect.Characteristics&imageScnCntCode != 0,
+			})
+		}
+		return sizeByAddr(addrs), nil
+	}
+	return nil, errors.New("unrecognized executable format")
+}
+
+// An addrSym is a symbol whose size must be inferred from its address.
+type addrSym struct {
+	name string
+	sect int
+	addr uint64
+	end  uint64 // end of the containing section
+	text bool
+}
+
+func sizeByAddr(addrs []addrSym) map[string]symbol {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if addrs[i].sect != addrs[j].sect {
+			return addrs[i].sect < addrs[j].sect
+		}
+		return addrs[i].addr < addrs[j].addr
+	})
+	m := make(map[string]symbol)
+	for i, s := range addrs {
+		end := s.end
+		if i+1 < len(addrs) && addrs[i+1].sect == s.sect {
+			end = addrs[i+1].addr
+		}
+		if end <= s.addr {
+			continue
+		}
+		sym := m[s.name]
+		sym.size += int64(end - s.addr)
+		sym.text = s.text
+		m[s.name] = sym
+	}
+	return m
+}
+
+// compareSymbols prints the text and data symbol totals for a binary
+// present in both before and after, followed by the n largest
+// added, removed, grown, and shrunk symbols, and records them in rep.
+func compareSymbols(name, beforePath, afterPath string, n int, rep *report) {
+	beforeMap, err := readSymbols(beforePath)
+	if err != nil {
+		return
+	}
+	afterMap, err := readSymbols(afterPath)
+	if err != nil {
+		return
+	}
+	rec := symbolRecord{Binary: name}
+	var added, removed, grown, shrunk []sizeRecord
+	for sym, b := range beforeMap {
+		a, ok := afterMap[sym]
+		switch {
+		case !ok:
+			removed = append(removed, sizeRecord{Name: sym, Before: b.size})
+		case a.size > b.size:
+			grown = append(grown, sizeRecord{Name: sym, Before: b.size, After: a.size})
+		case a.size < b.size:
+			shrunk = append(shrunk, sizeRecord{Name: sym, Before: b.size, After: a.size})
+		}
+		if b.text {
+			rec.TextBefore += b.size
+		} else {
+			rec.DataBefore += b.size
+		}
+	}
+	for sym, a := range afterMap {
+		if _, ok := beforeMap[sym]; !ok {
+			added = append(added, sizeRecord{Name: sym, After: a.size})
+		}
+		if a.text {
+			rec.TextAfter += a.size
+		} else {
+			rec.DataAfter += a.size
+		}
+	}
+	rec.Added = topSizeChanges(added, n)
+	rec.Removed = topSizeChanges(removed, n)
+	rec.Grown = topSizeChanges(grown, n)
+	rec.Shrunk = topSizeChanges(shrunk, n)
+	rep.Symbols = append(rep.Symbols, rec)
+
+	fmt.Fprintf(stdout, "\n%s%s%s symbols%s\n", ansiFgYellow, ansiBold, name, ansiReset)
+	sizes := newSizeTable(stdout, "symbols")
+	sizes.add("text", rec.TextBefore, rec.TextAfter)
+	sizes.add("data", rec.DataBefore, rec.DataAfter)
+	sizes.flush("symbol")
+	w := tabwriter.NewWriter(stdout, 8, 8, 1, ' ', 0)
+	for _, list := range []struct {
+		desc    string
+		records []sizeRecord
+	}{
+		{"added", rec.Added},
+		{"removed", rec.Removed},
+		{"grown", rec.Grown},
+		{"shrunk", rec.Shrunk},
+	} {
+		for _, r := range list.records {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%+d\t\n", list.desc, r.Name, r.Before, r.After, r.After-r.Before)
+		}
+	}
+	w.Flush()
+}
+
+// topSizeChanges returns the n records in x with the largest size change.
+func topSizeChanges(x []sizeRecord, n int) []sizeRecord {
+	abs := func(r sizeRecord) int64 {
+		d := r.After - r.Before
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	sort.Slice(x, func(i, j int) bool {
+		if abs(x[i]) != abs(x[j]) {
+			return abs(x[i]) > abs(x[j])
+		}
+		return x[i].Name < x[j].Name
+	})
+	if len(x) > n {
+		x = x[:n]
+	}
+	return x
+}
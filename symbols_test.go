@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSymbols(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds executables")
+	}
+	// Test executables are stripped, so build some that aren't.
+	dir := t.TempDir()
+	src := filepath.Join(dir, "x.go")
+	if err := os.WriteFile(src, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, platform := range []string{"linux/amd64", "darwin/arm64", "windows/amd64"} {
+		goos, goarch := parsePlatform(platform)
+		exe := filepath.Join(dir, goos+"_"+goarch)
+		cmd := exec.Command("go", "build", "-o", exe, src)
+		cmd.Env = append(os.Environ(), "GOOS="+goos, "GOARCH="+goarch, "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", platform, err, out)
+		}
+		m, err := readSymbols(exe)
+		if err != nil {
+			t.Fatalf("%s: %v", platform, err)
+		}
+		sym, ok := m["main.main"]
+		if !ok || sym.size == 0 || !sym.text {
+			t.Errorf("%s: main.main = %+v, %v; want sized text symbol", platform, sym, ok)
+		}
+	}
+}
+
+func TestTopSizeChanges(t *testing.T) {
+	in := []sizeRecord{
+		{Name: "a", Before: 10, After: 11},
+		{Name: "b", Before: 10, After: 1},
+		{Name: "c", After: 5},
+		{Name: "d", Before: 5},
+	}
+	got := topSizeChanges(in, 3)
+	want := []sizeRecord{
+		{Name: "b", Before: 10, After: 1},
+		{Name: "c", After: 5},
+		{Name: "d", Before: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("topSizeChanges = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A trendPoint summarizes one commit in an -each series.
+type trendPoint struct {
+	commit   reportCommit
+	binaries int64              // total binary size
+	text     int64              // total function text size; 0 without -fn
+	changed  []sizeRecord       // binaries whose size changed from the previous commit, largest change first
+	bench    map[string]float64 // benchmark medians, keyed by name and unit
+}
+
+// trendTopBinaries is the number of changed binaries shown for each commit.
+const trendTopBinaries = 3
+
+// trendPoints returns the commits in reps, which compare consecutive commits
+// of a series on a single platform, starting with the first before commit.
+func trendPoints(reps []*report) []trendPoint {
+	if len(reps) == 0 {
+		return nil
+	}
+	points := []trendPoint{{commit: reps[0].Before, bench: make(map[string]float64)}}
+	for _, r := range reps[0].Binaries {
+		points[0].binaries += r.Before
+	}
+	for _, r := range reps[0].TextSizes {
+		points[0].text += r.Before
+	}
+	for _, row := range reps[0].Bench {
+		points[0].bench[row.Name+" "+row.Unit] = row.BeforeMedian
+	}
+	for _, rep := range reps {
+		p := trendPoint{commit: rep.After, bench: make(map[string]float64)}
+		var changed []sizeRecord
+		for _, r := range rep.Binaries {
+			p.binaries += r.After
+			if r.Before != r.After {
+				changed = append(changed, r)
+			}
+		}
+		p.changed = topSizeChanges(changed, trendTopBinaries)
+		for _, r := range rep.TextSizes {
+			p.text += r.After
+		}
+		for _, row := range rep.Bench {
+			p.bench[row.Name+" "+row.Unit] = row.AfterMedian
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// benchKeys returns the benchmark keys present in any of points, sorted.
+func benchKeys(points []trendPoint) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, p := range points {
+		for k := range p.bench {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nsGeomean returns the geometric mean of the ns/op medians at p.
+func (p trendPoint) nsGeomean() float64 {
+	var x []float64
+	for k, v := range p.bench {
+		if strings.HasSuffix(k, " ns/op") {
+			x = append(x, v)
+		}
+	}
+	return geomean(x)
+}
+
+func formatChanged(changed []sizeRecord) string {
+	var s []string
+	for _, r := range changed {
+		s = append(s, fmt.Sprintf("%s %+d", r.Name, r.After-r.Before))
+	}
+	return strings.Join(s, ", ")
+}
+
+func pctChange(from, to float64) string {
+	if from == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+.2f%%", 100*to/from-100)
+}
+
+// printTrend prints a table with a row for each of points,
+// showing the incremental and cumulative changes from the first.
+func printTrend(w io.Writer, points []trendPoint) {
+	if len(points) == 0 {
+		return
+	}
+	first := points[0]
+	hasText := first.text != 0
+	hasBench := len(first.bench) > 0
+	tw := tabwriter.NewWriter(w, 8, 8, 1, ' ', 0)
+	fmt.Fprint(tw, "commit\tbinaries\tΔ\tcum Δ\t")
+	if hasText {
+		fmt.Fprint(tw, "text\tΔ\tcum Δ\t")
+	}
+	if hasBench {
+		fmt.Fprint(tw, "ns/op\tΔ\tcum Δ\t")
+	}
+	fmt.Fprintln(tw, "top changed binaries")
+	for i, p := range points {
+		prev := p
+		if i > 0 {
+			prev = points[i-1]
+		}
+		fmt.Fprintf(tw, "%s %s\t%d\t%+d\t%+d\t", shortsha(p.commit.SHA), trimSubject(p.commit.Subject), p.binaries, p.binaries-prev.binaries, p.binaries-first.binaries)
+		if hasText {
+			fmt.Fprintf(tw, "%d\t%+d\t%+d\t", p.text, p.text-prev.text, p.text-first.text)
+		}
+		if hasBench {
+			g := p.nsGeomean()
+			fmt.Fprintf(tw, "%s\t%s\t%s\t", formatValue(g, "ns/op"), pctChange(prev.nsGeomean(), g), pctChange(first.nsGeomean(), g))
+		}
+		fmt.Fprintln(tw, formatChanged(p.changed))
+	}
+	tw.Flush()
+}
+
+// trimSubject shortens a commit subject for display in a table.
+func trimSubject(s string) string {
+	const max = 40
+	if r := []rune(s); len(r) > max {
+		return string(r[:max-1]) + "…"
+	}
+	return s
+}
+
+// A trendSeries is the trend of an -each series on one platform.
+type trendSeries struct {
+	platform string
+	points   []trendPoint
+}
+
+// writeTrendCSV writes series as CSV, with a row for each point
+// and a column for each benchmark median.
+func writeTrendCSV(w io.Writer, series []trendSeries) error {
+	var all []trendPoint
+	for _, s := range series {
+		all = append(all, s.points...)
+	}
+	keys := benchKeys(all)
+	cw := csv.NewWriter(w)
+	header := []string{"platform", "sha", "subject",
+		"binaries", "binaries delta", "binaries cumulative delta",
+		"text", "text delta", "text cumulative delta",
+		"top changed binaries"}
+	header = append(header, keys...)
+	cw.Write(header)
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	for _, s := range series {
+		for i, p := range s.points {
+			first, prev := s.points[0], p
+			if i > 0 {
+				prev = s.points[i-1]
+			}
+			rec := []string{s.platform, p.commit.SHA, p.commit.Subject,
+				itoa(p.binaries), itoa(p.binaries - prev.binaries), itoa(p.binaries - first.binaries),
+				itoa(p.text), itoa(p.text - prev.text), itoa(p.text - first.text),
+				formatChanged(p.changed)}
+			for _, k := range keys {
+				v, ok := p.bench[k]
+				if !ok {
+					rec = append(rec, "")
+					continue
+				}
+				rec = append(rec, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+			cw.Write(rec)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printTrends prints a trend table for each platform in reps,
+// which are the comparisons of an -each series, and writes them to -csv if set.
+func printTrends(reps []*report) {
+	var series []trendSeries
+	byPlatform := make(map[string][]*report)
+	for _, rep := range reps {
+		byPlatform[rep.Platform] = append(byPlatform[rep.Platform], rep)
+	}
+	for _, rep := range reps {
+		if byPlatform[rep.Platform] == nil {
+			continue
+		}
+		series = append(series, trendSeries{platform: rep.Platform, points: trendPoints(byPlatform[rep.Platform])})
+		byPlatform[rep.Platform] = nil
+	}
+	for _, s := range series {
+		fmt.Fprintln(stdout, "---")
+		fmt.Fprintf(stdout, "trend (%s)\n", s.platform)
+		printTrend(stdout, s.points)
+	}
+	if *flagCSV != "" {
+		f, err := os.Create(*flagCSV)
+		check(err)
+		check(writeTrendCSV(f, series))
+		check(f.Close())
+	}
+}
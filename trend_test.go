@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTrend(t *testing.T) {
+	c0 := reportCommit{SHA: "0000000000", Subject: "base"}
+	c1 := reportCommit{SHA: "1111111111", Subject: "one"}
+	c2 := reportCommit{SHA: "2222222222", Subject: "two, with a comma"}
+	reps := []*report{
+		{
+			Before: c0, After: c1, Platform: "linux/amd64",
+			Binaries: []sizeRecord{{"compile", 1000, 1100}, {"link", 500, 500}},
+			Bench:    []benchRow{{Name: "Template", Unit: "ns/op", BeforeMedian: 100, AfterMedian: 110}},
+		},
+		{
+			Before: c1, After: c2, Platform: "linux/amd64",
+			Binaries: []sizeRecord{{"compile", 1100, 1050}, {"link", 500, 520}},
+			Bench:    []benchRow{{Name: "Template", Unit: "ns/op", BeforeMedian: 110, AfterMedian: 99}},
+		},
+	}
+	points := trendPoints(reps)
+	if len(points) != 3 {
+		t.Fatalf("got %d points, want 3", len(points))
+	}
+	for i, want := range []int64{1500, 1600, 1570} {
+		if points[i].binaries != want {
+			t.Errorf("points[%d].binaries = %d, want %d", i, points[i].binaries, want)
+		}
+	}
+	if got := formatChanged(points[2].changed); got != "compile -50, link +20" {
+		t.Errorf("points[2] changed = %q", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTrendCSV(&buf, []trendSeries{{"linux/amd64", points}}); err != nil {
+		t.Fatal(err)
+	}
+	want := `platform,sha,subject,binaries,binaries delta,binaries cumulative delta,text,text delta,text cumulative delta,top changed binaries,Template ns/op
+linux/amd64,0000000000,base,1500,0,0,0,0,0,,100
+linux/amd64,1111111111,one,1600,100,100,0,0,0,compile +100,110
+linux/amd64,2222222222,"two, with a comma",1570,-30,70,0,0,0,"compile -50, link +20",99
+`
+	if got := buf.String(); got != want {
+		t.Errorf("CSV:\n%s\nwant:\n%s", got, want)
+	}
+
+	buf.Reset()
+	printTrend(&buf, points)
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 {
+		t.Errorf("printTrend printed %d lines, want 4:\n%s", len(lines), buf.String())
+	}
+}